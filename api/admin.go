@@ -0,0 +1,37 @@
+// admin.go
+package api
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "os"
+
+    "github.com/gin-gonic/gin"
+)
+
+// isAdminRequest 檢查 X-Admin-Token 是否與環境變數 ADMIN_TOKEN 相符，沒有設定 ADMIN_TOKEN 時一律不是管理員
+func isAdminRequest(c *gin.Context) bool {
+    token := os.Getenv("ADMIN_TOKEN")
+    if token == "" {
+        return false
+    }
+    return subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) == 1
+}
+
+// isPOSRequest 檢查 X-POS-Token 是否與環境變數 POS_CALLBACK_TOKEN 相符，POS 付款完成後以此回呼更新訂單狀態
+func isPOSRequest(c *gin.Context) bool {
+    token := os.Getenv("POS_CALLBACK_TOKEN")
+    if token == "" {
+        return false
+    }
+    return subtle.ConstantTimeCompare([]byte(c.GetHeader("X-POS-Token")), []byte(token)) == 1
+}
+
+// requireAdmin 只允許管理員呼叫的路由
+func requireAdmin(c *gin.Context) {
+    if !isAdminRequest(c) {
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "只有管理員可以執行此操作"})
+        return
+    }
+    c.Next()
+}
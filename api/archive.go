@@ -0,0 +1,146 @@
+// archive.go
+package api
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 預設保留過期時段上限的天數，超過後移到封存表
+const defaultRetentionDays = 30
+
+// ArchivedDateLimit 表示封存的時段上限及當時的已訂份數
+type ArchivedDateLimit struct {
+    LocationID int    `json:"location_id"`
+    Date       string `json:"date"`
+    TimeSlot   string `json:"time_slot"`
+    LimitCount int    `json:"limit_count"`
+    Booked     int    `json:"booked"`
+    Origin     string `json:"origin"`
+}
+
+// retentionDays 從環境變數 DATE_LIMIT_RETENTION_DAYS 讀取保留天數，0 表示隔天就封存
+func retentionDays() int {
+    days, err := strconv.Atoi(os.Getenv("DATE_LIMIT_RETENTION_DAYS"))
+    if err != nil || days < 0 {
+        return defaultRetentionDays
+    }
+    return days
+}
+
+// ArchivePastDateLimits 在同一個交易中把 before 之前的時段上限連同已訂份數寫入封存表，
+// 再刪除 DateLimits 及沒有任何據點使用的 Dates，回傳封存的筆數及刪除的日期數
+func ArchivePastDateLimits(before string) (int, int, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, 0, err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`INSERT INTO DateLimitArchive (LocationID, Date, TimeSlot, LimitCount, Booked, Origin)
+        SELECT l.LocationID, l.Date, l.TimeSlot, l.LimitCount,
+            (SELECT COALESCE(SUM(op.quantity), 0) FROM orders o JOIN order_products op ON op.order_id = o.id
+                WHERE o.location_id = l.LocationID AND o.delivery_date = l.Date
+                AND o.delivery_time_range = l.TimeSlot AND o.status_code NOT IN (` + releasedStatuses + `)),
+            l.Origin
+        FROM DateLimits l WHERE l.Date < ?
+        ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount), Booked = VALUES(Booked), Origin = VALUES(Origin)`, before)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    res, err := tx.Exec("DELETE FROM DateLimits WHERE Date < ?", before)
+    if err != nil {
+        return 0, 0, err
+    }
+    archived, _ := res.RowsAffected()
+
+    res, err = tx.Exec("DELETE FROM Dates WHERE Date < ? AND NOT EXISTS (SELECT 1 FROM DateLimits l WHERE l.Date = Dates.Date)", before)
+    if err != nil {
+        return 0, 0, err
+    }
+    purged, _ := res.RowsAffected()
+
+    return int(archived), int(purged), tx.Commit()
+}
+
+// archiveDateLimits 排程工作：封存超過保留天數的時段上限，回傳執行摘要
+func archiveDateLimits(ctx context.Context) (string, error) {
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    before := time.Now().In(businessLocation()).AddDate(0, 0, -retentionDays()).Format("2006-01-02")
+    archived, purged, err := ArchivePastDateLimits(before)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("archived %d slots and purged %d dates before %s", archived, purged, before), nil
+}
+
+// FetchArchivedDateLimits 取得據點某月份 (YYYY-MM) 封存的時段上限
+func FetchArchivedDateLimits(locationID int, month string) ([]ArchivedDateLimit, error) {
+    start, err := time.Parse("2006-01", month)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := db.Query(`SELECT LocationID, DATE_FORMAT(Date, '%Y-%m-%d'), TimeSlot, LimitCount, Booked, Origin
+        FROM DateLimitArchive WHERE LocationID = ? AND Date >= ? AND Date < ? ORDER BY Date, TimeSlot`,
+        locationID, start.Format("2006-01-02"), start.AddDate(0, 1, 0).Format("2006-01-02"))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    limits := []ArchivedDateLimit{}
+    for rows.Next() {
+        var limit ArchivedDateLimit
+        if err := rows.Scan(&limit.LocationID, &limit.Date, &limit.TimeSlot, &limit.LimitCount, &limit.Booked, &limit.Origin); err != nil {
+            return nil, err
+        }
+        limits = append(limits, limit)
+    }
+
+    return limits, rows.Err()
+}
+
+// GetDateLimitArchive 依月份查詢封存的時段上限及已訂份數
+func GetDateLimitArchive(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    month := c.Query("month")
+    if _, err := time.Parse("2006-01", month); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供月份 (YYYY-MM)"})
+        return
+    }
+
+    limits, err := FetchArchivedDateLimits(locationID, month)
+    if err != nil {
+        log.Printf("FetchArchivedDateLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取封存資料"})
+        return
+    }
+
+    totalLimit, totalBooked := 0, 0
+    for _, limit := range limits {
+        totalLimit += limit.LimitCount
+        totalBooked += limit.Booked
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "month":        month,
+        "total_limit":  totalLimit,
+        "total_booked": totalBooked,
+        "limits":       limits,
+    })
+}
@@ -0,0 +1,136 @@
+// audit.go
+package api
+
+import (
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 變更來源
+const (
+    auditSourceAPI       = "api"
+    auditSourceScheduler = "scheduler"
+    auditSourceBulk      = "bulk"
+)
+
+// Actor 表示誰透過什麼方式修改上限
+type Actor struct {
+    Name   string
+    Source string
+}
+
+var schedulerActor = Actor{Name: "scheduler", Source: auditSourceScheduler}
+
+// requestActor 依驗證過的身分記錄操作人員，不採用用戶端自行提供的名稱。
+// 管理員記為環境變數 ADMIN_NAME (預設 admin)，POS 回呼記為 pos，其餘以來源 IP 記錄
+func requestActor(c *gin.Context, source string) Actor {
+    switch {
+    case isAdminRequest(c):
+        name := os.Getenv("ADMIN_NAME")
+        if name == "" {
+            name = "admin"
+        }
+        return Actor{Name: name, Source: source}
+    case isPOSRequest(c):
+        return Actor{Name: "pos", Source: source}
+    }
+    return Actor{Name: c.ClientIP(), Source: source}
+}
+
+// intPtr 紀錄中的舊值或新值
+func intPtr(v int) *int {
+    return &v
+}
+
+// recordAudit 寫入變更紀錄，應與修改在同一個交易中，失敗時整筆修改不生效
+func recordAudit(q execer, actor Actor, entry CapacityAudit) error {
+    var date interface{}
+    if entry.Date != "" {
+        date = entry.Date
+    }
+    _, err := q.Exec("INSERT INTO CapacityAudit (LocationID, Scope, Date, TimeSlot, ZoneID, OldValue, NewValue, Actor, Source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        entry.LocationID, entry.Scope, date, entry.TimeSlot, entry.ZoneID, entry.OldValue, entry.NewValue, actor.Name, actor.Source)
+    return err
+}
+
+// auditDateLimit 記錄特定日期時段上限的變更，old 為 nil 表示新增
+func auditDateLimit(q execer, actor Actor, locationID int, date, timeSlot string, old *int, newValue int) error {
+    if old != nil && *old == newValue {
+        return nil
+    }
+    return recordAudit(q, actor, CapacityAudit{
+        LocationID: locationID,
+        Scope:      "date",
+        Date:       date,
+        TimeSlot:   timeSlot,
+        OldValue:   old,
+        NewValue:   intPtr(newValue),
+    })
+}
+
+// GetCapacityAudit 查詢變更紀錄，可用 location_id、date、time_slot、actor、source、limit 篩選
+func GetCapacityAudit(c *gin.Context) {
+    query := "SELECT ID, LocationID, Scope, COALESCE(DATE_FORMAT(Date, '%Y-%m-%d'), ''), TimeSlot, ZoneID, OldValue, NewValue, Actor, Source, DATE_FORMAT(CreatedAt, '%Y-%m-%d %H:%i:%s') FROM CapacityAudit WHERE 1 = 1"
+    var args []interface{}
+
+    if c.Query("location_id") != "" {
+        locationID, err := parseLocationID(c)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        query += " AND LocationID = ?"
+        args = append(args, locationID)
+    }
+
+    // 只允許固定的欄位篩選
+    filters := []struct{ param, column string }{
+        {"date", "Date"},
+        {"time_slot", "TimeSlot"},
+        {"actor", "Actor"},
+        {"source", "Source"},
+    }
+    for _, f := range filters {
+        if value := c.Query(f.param); value != "" {
+            query += " AND " + f.column + " = ?"
+            args = append(args, value)
+        }
+    }
+
+    limit := 100
+    if limitStr := c.Query("limit"); limitStr != "" {
+        n, err := strconv.Atoi(limitStr)
+        if err != nil || n <= 0 || n > 1000 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "無效的筆數"})
+            return
+        }
+        limit = n
+    }
+    query += " ORDER BY ID DESC LIMIT ?"
+    args = append(args, limit)
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        log.Printf("GetCapacityAudit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取變更紀錄"})
+        return
+    }
+    defer rows.Close()
+
+    entries := []CapacityAudit{}
+    for rows.Next() {
+        var entry CapacityAudit
+        if err := rows.Scan(&entry.ID, &entry.LocationID, &entry.Scope, &entry.Date, &entry.TimeSlot, &entry.ZoneID, &entry.OldValue, &entry.NewValue, &entry.Actor, &entry.Source, &entry.CreatedAt); err != nil {
+            log.Printf("GetCapacityAudit scan error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取變更紀錄"})
+            return
+        }
+        entries = append(entries, entry)
+    }
+
+    c.JSON(http.StatusOK, entries)
+}
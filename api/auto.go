@@ -2,45 +2,86 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // TriggerAutoCreateLimits 自動創建預設日期
 func TriggerAutoCreateLimits(c *gin.Context)  {
-    period := c.Query("add") // 從查詢參數獲取時間範圍oneWeek、twoWeeks、oneMonth
+    period := c.Query("add") // 從查詢參數獲取時間範圍oneWeek、twoWeeks、oneMonth，或用 start_date、end_date 指定
     coverStr :=c.Query("cover")
     //預設覆蓋false
     cover :=false
     if coverStr == "true"{
         cover = true
     }
+    // 覆蓋時預設保留人工修改過的時段，force=true 才一併覆蓋
+    force := c.Query("force") == "true"
 
     // 如果沒有提供時間範圍，則預設為兩個月
     if period == "" {
         period = "twoMonths"
     }
 
-    err := AutoCreateNextTwoMonthsLimits(period , cover)
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    opts := AutoCreateOptions{
+        Period:    period,
+        StartDate: c.Query("start_date"),
+        EndDate:   c.Query("end_date"),
+        Cover:     cover,
+        Force:     force,
+        DryRun:    c.Query("dry_run") == "true",
+        Forecast:  c.Query("forecast") == "true",
+        Origin:    originTemplate,
+        Actor:     requestActor(c, auditSourceAPI),
+    }
+    if _, _, err := autoCreateRange(opts); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    report, err := AutoCreateNextTwoMonthsLimits(locationID, opts)
     if err != nil {
          c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
          return
     }
-     c.JSON(http.StatusOK, gin.H{"message": "已成功新增時段預設"})
+     message := "已成功新增時段預設"
+     if opts.DryRun {
+         message = "預覽，尚未寫入"
+     }
+     c.JSON(http.StatusOK, gin.H{
+         "message":     message,
+         "start_date":  report.StartDate,
+         "end_date":    report.EndDate,
+         "dry_run":     report.DryRun,
+         "created":     report.Created,
+         "overwritten": report.Overwritten,
+         "skipped":     report.Skipped,
+     })
      
 }
 
 
-var schedulerActive bool
-var ticker *time.Ticker
+// 每天自動新增時段的排程工作名稱
+const autoCreateJobName = "auto-create-limits"
 
 // StartSchedulerHandler 定時新增
 func StartSchedulerHandler(c *gin.Context)  {
-    if !schedulerActive {
-        StartScheduler()
-        schedulerActive = true
+    if !scheduler.IsJobActive(autoCreateJobName) {
+        if err := scheduler.StartJob(autoCreateJobName); err != nil {
+            log.Printf("StartJob error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "定時任務啟動失敗"})
+            return
+        }
          c.JSON(http.StatusOK,gin.H{"message": "定時自動新增已啟動"})
          return
     }
@@ -49,56 +90,53 @@ func StartSchedulerHandler(c *gin.Context)  {
 
 // StopSchedulerHandler 停止定時
 func StopSchedulerHandler(c *gin.Context)  {
-    if schedulerActive {
-        StopScheduler()
-        schedulerActive = false
+    if scheduler.IsJobActive(autoCreateJobName) {
+        if err := scheduler.StopJob(autoCreateJobName); err != nil {
+            log.Printf("StopJob error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "定時任務停止失敗"})
+            return
+        }
          c.JSON(http.StatusOK,gin.H{"message": "定時任務已停止"})
          return
     }
      c.JSON(http.StatusBadRequest, gin.H{"error": "定時任務未運作"})
 }
 
-func StartScheduler() {
-    // 計算現在時間到今天早上6點的時間差
-    now := time.Now()
-    next := time.Date(now.Year(), now.Month(), now.Day(), 6, 0, 0, 0, now.Location())
-    if now.After(next) {
-        // 如果現在時間已經過了今天的6點，那麼設定下一個觸發時間為明天6點
-        next = next.Add(24 * time.Hour)
+// autoCreateAllLocations 依各據點自己的預設模板分別新增，單一據點失敗不影響其他據點，回傳執行摘要。
+// ctx 取消時不再處理剩下的據點
+func autoCreateAllLocations(ctx context.Context, period string, cover, forecast bool) (string, error) {
+    locationIDs, err := FetchLocationIDs()
+    if err != nil {
+        return "", err
     }
 
-    // 計算時間差
-    duration := next.Sub(now)
-
-    // 設置一個一次性的定時器，在今天或明天的早上6點觸發
-    time.AfterFunc(duration, func() {
-        AutoCreateNextTwoMonthsLimits("oneMonth", false)
-        // 現在設置每24小時觸發一次的定時器
-        ticker = time.NewTicker(24 * time.Hour)
-        go func() {
-            for {
-                select {
-                case <-ticker.C:
-                    AutoCreateNextTwoMonthsLimits("oneMonth", false)
-                }
-            }
-        }()
-    })
-}
-
-
-func StopScheduler() {
-    if ticker != nil {
-        ticker.Stop()
+    total := 0
+    var failed []int
+    done := 0
+    for _, locationID := range locationIDs {
+        if err := ctx.Err(); err != nil {
+            return fmt.Sprintf("created %d slots for %d of %d locations", total, done, len(locationIDs)), err
+        }
+        done++
+        report, err := AutoCreateNextTwoMonthsLimits(locationID, AutoCreateOptions{
+            Period:   period,
+            Cover:    cover,
+            Forecast: forecast,
+            Origin:   originAuto,
+            Actor:    schedulerActor,
+        })
+        if report != nil {
+            total += len(report.Created)
+        }
+        if err != nil {
+            log.Printf("據點 %d 自動新增失敗: %v", locationID, err)
+            failed = append(failed, locationID)
+        }
     }
-}
 
-// GetSchedulerStatusHandler 定時的狀態
-func GetSchedulerStatusHandler(c *gin.Context)  {
-    status := gin.H{"schedulerActive": schedulerActive}
-     c.JSON(http.StatusOK, status)
+    summary := fmt.Sprintf("created %d slots for %d locations", total, len(locationIDs))
+    if len(failed) > 0 {
+        return summary, fmt.Errorf("據點 %v 自動新增失敗", failed)
+    }
+    return summary, nil
 }
-
-
-
-
@@ -0,0 +1,301 @@
+// bulkLimit.go
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 單次批次修改最多涵蓋的天數
+const maxBulkDays = 366
+
+// BulkUpdateDateLimits 批次修改日期範圍內的時段上限，dry_run 時只回傳變更清單不寫入
+func BulkUpdateDateLimits(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    var req BulkLimitRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateBulkLimitRequest(req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    changes, skipped, err := BulkEditDateLimits(locationID, req, requestActor(c, auditSourceBulk))
+    if err != nil {
+        log.Printf("BulkEditDateLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "批次修改失敗，所有變更已取消"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "dry_run": req.DryRun,
+        "changes": changes,
+        "skipped": skipped,
+    })
+}
+
+// parseDateRange 解析開始及結束日期 (含)，範圍不可超過 maxBulkDays 天
+func parseDateRange(startDate, endDate string) (time.Time, time.Time, error) {
+    start, err := time.Parse("2006-01-02", startDate)
+    if err != nil {
+        return time.Time{}, time.Time{}, errors.New("無效的開始日期")
+    }
+    end, err := time.Parse("2006-01-02", endDate)
+    if err != nil {
+        return time.Time{}, time.Time{}, errors.New("無效的結束日期")
+    }
+    if end.Before(start) {
+        return time.Time{}, time.Time{}, errors.New("結束日期不可早於開始日期")
+    }
+    if end.Sub(start) > maxBulkDays*24*time.Hour {
+        return time.Time{}, time.Time{}, fmt.Errorf("日期範圍不可超過 %d 天", maxBulkDays)
+    }
+    return start, end, nil
+}
+
+// validateBulkLimitRequest 檢查批次修改的參數
+func validateBulkLimitRequest(req BulkLimitRequest) error {
+    if _, _, err := parseDateRange(req.StartDate, req.EndDate); err != nil {
+        return err
+    }
+    for _, weekday := range req.Weekdays {
+        if weekday < 0 || weekday > 6 {
+            return errors.New("無效的星期")
+        }
+    }
+
+    switch req.Action {
+    case "set":
+        if req.Value < 0 {
+            return errors.New("數量不可為負數")
+        }
+    case "scale":
+        if req.Value < 0 {
+            return errors.New("百分比不可為負數")
+        }
+    case "forecast":
+        if req.Value < 1 || req.Value > maxForecastWeeks {
+            return fmt.Errorf("參考週數需介於 1 到 %d", maxForecastWeeks)
+        }
+    case "add", "close":
+    default:
+        return fmt.Errorf("不支援的動作: %s", req.Action)
+    }
+    return nil
+}
+
+// applyBulkAction 依動作計算新的上限，結果不會小於 0
+func applyBulkAction(action string, current, value int) int {
+    var next int
+    switch action {
+    case "set":
+        next = value
+    case "add":
+        next = current + value
+    case "scale":
+        next = int(math.Round(float64(current) * float64(value) / 100))
+    case "close":
+        next = 0
+    }
+    if next < 0 {
+        next = 0
+    }
+    return next
+}
+
+// BulkEditDateLimits 在同一個交易中套用批次修改，回傳變更及因沒有設定或沒有歷史資料而略過的時段
+func BulkEditDateLimits(locationID int, req BulkLimitRequest, actor Actor) ([]LimitChange, []LimitChange, error) {
+    start, _ := time.Parse("2006-01-02", req.StartDate)
+    end, _ := time.Parse("2006-01-02", req.EndDate)
+
+    // 取得預設模板，新增時段時以模板數量為基準
+    template := make(map[string]int)
+    templateLimits, err := FetchTimeSlotLimits(locationID)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, limit := range templateLimits {
+        template[limit.TimeSlot] = limit.LimitCount
+    }
+
+    // forecast 時以預測的建議上限為新值
+    var model *forecastModel
+    if req.Action == "forecast" {
+        if model, err = buildForecastModel(locationID, time.Now(), req.Value); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    weekdays := make(map[time.Weekday]bool)
+    for _, weekday := range req.Weekdays {
+        weekdays[time.Weekday(weekday)] = true
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, nil, err
+    }
+    defer tx.Rollback()
+
+    existing, err := lockDateLimits(tx, locationID, req.StartDate, req.EndDate)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    changes := []LimitChange{}
+    skipped := []LimitChange{}
+    for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+        if len(weekdays) > 0 && !weekdays[d.Weekday()] {
+            continue
+        }
+        dateStr := d.Format("2006-01-02")
+
+        for _, timeSlot := range bulkTimeSlots(req, existing[dateStr], template) {
+            current, exists := existing[dateStr][timeSlot]
+            if !exists && !req.Upsert {
+                skipped = append(skipped, LimitChange{Date: dateStr, TimeSlot: timeSlot, Reason: "no_limit"})
+                continue
+            }
+
+            base := current
+            if !exists {
+                base = template[timeSlot]
+            }
+            next := applyBulkAction(req.Action, base, req.Value)
+            if model != nil {
+                // 沒有歷史資料的時段無從預測，保留原本的上限並列為略過
+                _, suggested, samples := model.suggest(d, timeSlot)
+                if samples == 0 {
+                    skipped = append(skipped, LimitChange{Date: dateStr, TimeSlot: timeSlot, Old: current, New: base, Reason: "no_samples"})
+                    continue
+                }
+                next = suggested
+            }
+            if exists && next == current {
+                continue
+            }
+
+            changes = append(changes, LimitChange{
+                Date:     dateStr,
+                TimeSlot: timeSlot,
+                Old:      current,
+                New:      next,
+                Created:  !exists,
+            })
+        }
+    }
+
+    if req.DryRun {
+        return changes, skipped, nil
+    }
+
+    for _, change := range changes {
+        if change.Created {
+            if err := insertDateIfNeededTx(tx, change.Date); err != nil {
+                return nil, nil, err
+            }
+        }
+        _, err := tx.Exec("INSERT INTO DateLimits (LocationID, Date, TimeSlot, LimitCount, Origin) VALUES (?, ?, ?, ?, 'manual') ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount), Origin = VALUES(Origin)",
+            locationID, change.Date, change.TimeSlot, change.New)
+        if err != nil {
+            return nil, nil, err
+        }
+
+        var old *int
+        if !change.Created {
+            old = intPtr(change.Old)
+        }
+        if err := auditDateLimit(tx, actor, locationID, change.Date, change.TimeSlot, old, change.New); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, nil, err
+    }
+
+    // 上限調高的時段提供給候補
+    for _, change := range changes {
+        if change.New > change.Old {
+            capacityFreed(locationID, change.Date, change.TimeSlot)
+        }
+    }
+    return changes, skipped, nil
+}
+
+// bulkTimeSlots 決定某日期要處理的時段：有指定就用指定的，否則為現有時段 (upsert 時加上模板時段)
+func bulkTimeSlots(req BulkLimitRequest, existing map[string]int, template map[string]int) []string {
+    if len(req.TimeSlots) > 0 {
+        return req.TimeSlots
+    }
+
+    seen := make(map[string]bool)
+    for timeSlot := range existing {
+        seen[timeSlot] = true
+    }
+    if req.Upsert {
+        for timeSlot := range template {
+            seen[timeSlot] = true
+        }
+    }
+
+    timeSlots := make([]string, 0, len(seen))
+    for timeSlot := range seen {
+        timeSlots = append(timeSlots, timeSlot)
+    }
+    sort.Strings(timeSlots)
+    return timeSlots
+}
+
+// lockDateLimits 鎖定並取得據點日期範圍內的時段上限
+func lockDateLimits(tx *sql.Tx, locationID int, startDate, endDate string) (map[string]map[string]int, error) {
+    rows, err := tx.Query("SELECT Date, TimeSlot, LimitCount FROM DateLimits WHERE LocationID = ? AND Date BETWEEN ? AND ? FOR UPDATE",
+        locationID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    limits := make(map[string]map[string]int)
+    for rows.Next() {
+        var date, timeSlot string
+        var limitCount int
+        if err := rows.Scan(&date, &timeSlot, &limitCount); err != nil {
+            return nil, err
+        }
+        if _, ok := limits[date]; !ok {
+            limits[date] = make(map[string]int)
+        }
+        limits[date][timeSlot] = limitCount
+    }
+
+    return limits, rows.Err()
+}
+
+// insertDateIfNeededTx 在交易中確保 Dates 表有該日期
+func insertDateIfNeededTx(tx *sql.Tx, date string) error {
+    var exists bool
+    if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Dates WHERE Date = ?)", date).Scan(&exists); err != nil {
+        return err
+    }
+    if exists {
+        return nil
+    }
+    _, err := tx.Exec("INSERT INTO Dates (Date) VALUES (?)", date)
+    return err
+}
@@ -0,0 +1,142 @@
+// capacity.go
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "os"
+    "time"
+)
+
+var (
+    errSlotFull = errors.New("該時段已額滿")
+    errZoneFull = errors.New("該區域此時段外送量已額滿")
+)
+
+// orderUnits 計算訂單佔用的份數 (主餐數量總和)
+func orderUnits(meals []OrderMeal) int {
+    units := 0
+    for _, meal := range meals {
+        units += meal.MainMeal.Quantity
+    }
+    return units
+}
+
+// FetchDateLimit 取得據點某日期時段的上限，沒有設定時 found 為 false
+func FetchDateLimit(locationID int, date, timeSlot string) (limit int, found bool, err error) {
+    err = db.QueryRow("SELECT LimitCount FROM DateLimits WHERE LocationID = ? AND Date = ? AND TimeSlot = ?", locationID, date, timeSlot).Scan(&limit)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return limit, true, nil
+}
+
+// queryer 讓查詢可以在 *sql.DB 或交易 *sql.Tx 中執行
+type queryer interface {
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rowsQueryer 讓多筆查詢可以在 *sql.DB 或交易 *sql.Tx 中執行
+type rowsQueryer interface {
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execer 讓寫入可以在 *sql.DB 或交易 *sql.Tx 中執行
+type execer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// requireSlotLimit 環境變數 REQUIRE_SLOT_LIMIT=true 時沒有設定時段上限的時段視為不開放，
+// 預設維持原本的行為，沒有設定上限則不限制
+func requireSlotLimit() bool {
+    return os.Getenv("REQUIRE_SLOT_LIMIT") == "true"
+}
+
+// lockSlotCapacity 在交易中鎖定時段上限，limited 為 false 表示沒有設定上限不需檢查；
+// 要求設定上限 (requireSlotLimit) 時沒有設定回傳 errSlotFull
+func lockSlotCapacity(tx *sql.Tx, locationID int, date, timeSlot string) (limit int, limited bool, err error) {
+    limit, found, err := lockDateLimit(tx, locationID, date, timeSlot)
+    if err != nil {
+        return 0, false, err
+    }
+    if !found && requireSlotLimit() {
+        return 0, false, errSlotFull
+    }
+    return limit, found, nil
+}
+
+// FetchBookedUnits 統計據點某日期時段非作廢訂單已訂的份數
+func FetchBookedUnits(locationID int, date, timeSlot string) (int, error) {
+    return bookedUnits(db, locationID, date, timeSlot)
+}
+
+func bookedUnits(q queryer, locationID int, date, timeSlot string) (int, error) {
+    var booked int
+    err := q.QueryRow(`SELECT COALESCE(SUM(op.quantity), 0)
+        FROM orders o JOIN order_products op ON op.order_id = o.id
+        WHERE o.location_id = ? AND o.delivery_date = ? AND o.delivery_time_range = ? AND o.status_code NOT IN (` + releasedStatuses + `)`,
+        locationID, date, timeSlot).Scan(&booked)
+    return booked, err
+}
+
+// checkOrderCapacity 在建立訂單的交易中鎖定時段，檢查是否超過時段總上限及外送區域上限。
+// 沒有設定時段上限時依 requireSlotLimit 決定不限制或不開放；沒有設定區域上限則不限制
+func checkOrderCapacity(tx *sql.Tx, req NewOrderRequest, zoneID int) error {
+    // 鎖定時段，同時建立的訂單及保留會依序檢查，避免超賣最後的名額
+    limit, limited, err := lockSlotCapacity(tx, req.LocationID, req.DeliveryDate, req.DeliveryTimeRange)
+    if err != nil {
+        return err
+    }
+
+    // 有保留憑證時須與訂單的日期時段相符且尚未過期
+    if req.HoldToken != "" {
+        var locationID int
+        var date, timeSlot string
+        err := tx.QueryRow(`SELECT LocationID, DATE_FORMAT(Date, '%Y-%m-%d'), TimeSlot FROM CapacityHolds
+            WHERE Token = ? AND Status = 'active' AND ExpiresAt > ? FOR UPDATE`, req.HoldToken, time.Now()).Scan(&locationID, &date, &timeSlot)
+        if err == sql.ErrNoRows {
+            return errHoldInvalid
+        }
+        if err != nil {
+            return err
+        }
+        if locationID != req.LocationID || date != req.DeliveryDate || timeSlot != req.DeliveryTimeRange {
+            return errHoldInvalid
+        }
+    }
+
+    if limited {
+        booked, err := bookedUnits(tx, req.LocationID, req.DeliveryDate, req.DeliveryTimeRange)
+        if err != nil {
+            return err
+        }
+        // 其他人保留中的名額也要扣除，訂單自己的保留不重複計算
+        held, err := heldUnits(tx, req.LocationID, req.DeliveryDate, req.DeliveryTimeRange, req.HoldToken)
+        if err != nil {
+            return err
+        }
+        if booked+held+orderUnits(req.OrderMeals) > limit {
+            return errSlotFull
+        }
+    }
+
+    // 外送區域上限，時段已鎖定所以同時段的外送筆數不會同時變動
+    if zoneID == 0 {
+        return nil
+    }
+    zoneLimit, found, err := zoneLimitCount(tx, req.LocationID, req.DeliveryDate, req.DeliveryTimeRange, zoneID)
+    if err != nil || !found {
+        return err
+    }
+    drops, err := zoneDrops(tx, req.LocationID, req.DeliveryDate, req.DeliveryTimeRange, zoneID)
+    if err != nil {
+        return err
+    }
+    if drops+1 > zoneLimit {
+        return errZoneFull
+    }
+    return nil
+}
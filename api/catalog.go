@@ -0,0 +1,891 @@
+// catalog.go
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+var errInvalidMeal = errors.New("餐點不符合商品目錄")
+
+// GetProducts 取得商品目錄，available=true 時只回傳供應中的商品及選項
+func GetProducts(c *gin.Context) {
+    products, err := FetchProducts(c.Query("available") == "true")
+    if err != nil {
+        log.Printf("FetchProducts error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取商品"})
+        return
+    }
+
+    c.JSON(http.StatusOK, products)
+}
+
+// GetProduct 取得單一商品及可選的選項群組
+func GetProduct(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的商品 ID"})
+        return
+    }
+
+    product, err := FetchProduct(id)
+    if err != nil {
+        log.Printf("FetchProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取商品"})
+        return
+    }
+    if product == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個商品"})
+        return
+    }
+
+    c.JSON(http.StatusOK, product)
+}
+
+// CreateProduct 新增商品
+func CreateProduct(c *gin.Context) {
+    // 沒有指定時預設供應中
+    product := Product{Available: true}
+    if err := c.ShouldBindJSON(&product); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateProduct(product); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    res, err := db.Exec("INSERT INTO products (name, description, price, image_id, available, sort_order) VALUES (?, ?, ?, ?, ?, ?)",
+        product.Name, product.Description, product.Price, product.ImageID, product.Available, product.SortOrder)
+    if err != nil {
+        log.Printf("CreateProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法新增商品"})
+        return
+    }
+    id, _ := res.LastInsertId()
+    product.ID = int(id)
+    product.OptionGroups = []OptionGroup{}
+
+    c.JSON(http.StatusCreated, product)
+}
+
+// UpdateProduct 修改商品，只更新送出的欄位，已成立的訂單保留下單當時的名稱及價格
+func UpdateProduct(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的商品 ID"})
+        return
+    }
+
+    // 以現有的商品為基礎套用送出的欄位，沒有送出的欄位 (例如 available) 維持原值
+    product, err := FetchProduct(id)
+    if err != nil {
+        log.Printf("FetchProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改商品"})
+        return
+    }
+    if product == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個商品"})
+        return
+    }
+    if err := c.ShouldBindJSON(product); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateProduct(*product); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    res, err := db.Exec("UPDATE products SET name = ?, description = ?, price = ?, image_id = ?, available = ?, sort_order = ? WHERE id = ?",
+        product.Name, product.Description, product.Price, product.ImageID, product.Available, product.SortOrder, id)
+    if err != nil {
+        log.Printf("UpdateProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改商品"})
+        return
+    }
+    if !productExists(id, res) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個商品"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "商品已更新"})
+}
+
+// DeleteProduct 刪除商品及其選項群組設定，已成立的訂單不受影響
+func DeleteProduct(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的商品 ID"})
+        return
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除商品"})
+        return
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("DELETE FROM product_option_group_links WHERE product_id = ?", id); err != nil {
+        log.Printf("DeleteProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除商品"})
+        return
+    }
+    res, err := tx.Exec("DELETE FROM products WHERE id = ?", id)
+    if err != nil {
+        log.Printf("DeleteProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除商品"})
+        return
+    }
+    if affected, _ := res.RowsAffected(); affected == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個商品"})
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("DeleteProduct error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除商品"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "商品已刪除"})
+}
+
+// GetOptionGroups 取得所有選項群組、套用的商品及選項
+func GetOptionGroups(c *gin.Context) {
+    groups, err := FetchOptionGroups(0, false)
+    if err != nil {
+        log.Printf("FetchOptionGroups error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取選項群組"})
+        return
+    }
+
+    c.JSON(http.StatusOK, groups)
+}
+
+// CreateOptionGroup 新增選項群組，可同時設定套用的商品及選項
+func CreateOptionGroup(c *gin.Context) {
+    var group OptionGroup
+    if err := c.ShouldBindJSON(&group); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateOptionGroup(group); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    for _, option := range group.Options {
+        if err := validateProductOption(option); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+
+    id, err := InsertOptionGroup(group)
+    if err != nil {
+        log.Printf("InsertOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法新增選項群組"})
+        return
+    }
+    group.ID = id
+
+    c.JSON(http.StatusCreated, group)
+}
+
+// UpdateOptionGroupRequest 修改選項群組的請求，沒有送出的欄位維持原值
+type UpdateOptionGroupRequest struct {
+    Name       *string `json:"name"`
+    MinSelect  *int    `json:"min_select"`
+    MaxSelect  *int    `json:"max_select"`
+    SortOrder  *int    `json:"sort_order"`
+    ProductIDs *[]int  `json:"product_ids"` // 有送出時取代原本套用的商品，空陣列表示不套用
+}
+
+// UpdateOptionGroup 修改選項群組，只更新送出的欄位，有送出 product_ids 時才取代原本套用的商品
+func UpdateOptionGroup(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的選項群組 ID"})
+        return
+    }
+
+    var req UpdateOptionGroupRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項群組"})
+        return
+    }
+    defer tx.Rollback()
+
+    var group OptionGroup
+    err = tx.QueryRow("SELECT id, name, min_select, max_select, sort_order FROM product_option_groups WHERE id = ? FOR UPDATE", id).Scan(
+        &group.ID, &group.Name, &group.MinSelect, &group.MaxSelect, &group.SortOrder)
+    if err == sql.ErrNoRows {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項群組"})
+        return
+    }
+    if err != nil {
+        log.Printf("UpdateOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項群組"})
+        return
+    }
+    if req.Name != nil {
+        group.Name = *req.Name
+    }
+    if req.MinSelect != nil {
+        group.MinSelect = *req.MinSelect
+    }
+    if req.MaxSelect != nil {
+        group.MaxSelect = *req.MaxSelect
+    }
+    if req.SortOrder != nil {
+        group.SortOrder = *req.SortOrder
+    }
+    if err := validateOptionGroup(group); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    _, err = tx.Exec("UPDATE product_option_groups SET name = ?, min_select = ?, max_select = ?, sort_order = ? WHERE id = ?",
+        group.Name, group.MinSelect, group.MaxSelect, group.SortOrder, id)
+    if err != nil {
+        log.Printf("UpdateOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項群組"})
+        return
+    }
+    if req.ProductIDs != nil {
+        if err := linkOptionGroup(tx, id, *req.ProductIDs); err != nil {
+            log.Printf("linkOptionGroup error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項群組"})
+            return
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("UpdateOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項群組"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "選項群組已更新"})
+}
+
+// DeleteOptionGroup 刪除選項群組及其中的選項
+func DeleteOptionGroup(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的選項群組 ID"})
+        return
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除選項群組"})
+        return
+    }
+    defer tx.Rollback()
+
+    for _, query := range []string{
+        "DELETE FROM product_options WHERE group_id = ?",
+        "DELETE FROM product_option_group_links WHERE group_id = ?",
+    } {
+        if _, err := tx.Exec(query, id); err != nil {
+            log.Printf("DeleteOptionGroup error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除選項群組"})
+            return
+        }
+    }
+    res, err := tx.Exec("DELETE FROM product_option_groups WHERE id = ?", id)
+    if err != nil {
+        log.Printf("DeleteOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除選項群組"})
+        return
+    }
+    if affected, _ := res.RowsAffected(); affected == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項群組"})
+        return
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("DeleteOptionGroup error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除選項群組"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "選項群組已刪除"})
+}
+
+// CreateProductOption 在選項群組中新增選項
+func CreateProductOption(c *gin.Context) {
+    groupID, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的選項群組 ID"})
+        return
+    }
+
+    // 沒有指定時預設供應中
+    option := ProductOption{Available: true}
+    if err := c.ShouldBindJSON(&option); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateProductOption(option); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    var exists bool
+    if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM product_option_groups WHERE id = ?)", groupID).Scan(&exists); err != nil {
+        log.Printf("CreateProductOption error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法新增選項"})
+        return
+    }
+    if !exists {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項群組"})
+        return
+    }
+
+    option.GroupID = groupID
+    id, err := insertProductOption(db, option)
+    if err != nil {
+        log.Printf("insertProductOption error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法新增選項"})
+        return
+    }
+    option.ID = id
+
+    c.JSON(http.StatusCreated, option)
+}
+
+// UpdateProductOption 修改選項，只更新送出的欄位
+func UpdateProductOption(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的選項 ID"})
+        return
+    }
+
+    // 與商品相同，沒有送出的欄位維持原值
+    option, err := fetchProductOption(id)
+    if err != nil {
+        log.Printf("fetchProductOption error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項"})
+        return
+    }
+    if option == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項"})
+        return
+    }
+    if err := c.ShouldBindJSON(option); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if err := validateProductOption(*option); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    res, err := db.Exec("UPDATE product_options SET name = ?, price = ?, image_id = ?, available = ?, sort_order = ? WHERE id = ?",
+        option.Name, option.Price, option.ImageID, option.Available, option.SortOrder, id)
+    if err != nil {
+        log.Printf("UpdateProductOption error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法修改選項"})
+        return
+    }
+    if !rowExists(db, "SELECT EXISTS(SELECT 1 FROM product_options WHERE id = ?)", id, res) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "選項已更新"})
+}
+
+// DeleteProductOption 刪除選項
+func DeleteProductOption(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的選項 ID"})
+        return
+    }
+
+    res, err := db.Exec("DELETE FROM product_options WHERE id = ?", id)
+    if err != nil {
+        log.Printf("DeleteProductOption error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除選項"})
+        return
+    }
+    if affected, _ := res.RowsAffected(); affected == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個選項"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "選項已刪除"})
+}
+
+// validateProduct 檢查商品欄位，有指定圖片時圖片須存在
+func validateProduct(product Product) error {
+    if product.Name == "" {
+        return errors.New("請提供商品名稱")
+    }
+    if product.Price < 0 {
+        return errors.New("價格不可為負數")
+    }
+    return validateImageID(product.ImageID)
+}
+
+func validateOptionGroup(group OptionGroup) error {
+    if group.Name == "" {
+        return errors.New("請提供選項群組名稱")
+    }
+    if group.MinSelect < 0 || group.MaxSelect < 0 {
+        return errors.New("選擇數量不可為負數")
+    }
+    if group.MaxSelect > 0 && group.MaxSelect < group.MinSelect {
+        return errors.New("最多選擇數量不可小於最少選擇數量")
+    }
+    return nil
+}
+
+func validateProductOption(option ProductOption) error {
+    if option.Name == "" {
+        return errors.New("請提供選項名稱")
+    }
+    if option.Price < 0 {
+        return errors.New("價格不可為負數")
+    }
+    return validateImageID(option.ImageID)
+}
+
+// validateImageID 確認圖片存在於圖片庫
+func validateImageID(imageID *int) error {
+    if imageID == nil {
+        return nil
+    }
+    var exists bool
+    if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM images WHERE id = ?)", *imageID).Scan(&exists); err != nil {
+        return err
+    }
+    if !exists {
+        return errors.New("沒有這張圖片")
+    }
+    return nil
+}
+
+// productExists UPDATE 沒有影響任何資料時確認商品是否存在 (資料相同時 MySQL 回傳 0)
+func productExists(id int, res sql.Result) bool {
+    return rowExists(db, "SELECT EXISTS(SELECT 1 FROM products WHERE id = ?)", id, res)
+}
+
+// fetchProductOption 取得單一選項，不存在時回傳 nil
+func fetchProductOption(id int) (*ProductOption, error) {
+    var option ProductOption
+    var imageID sql.NullInt64
+    err := db.QueryRow("SELECT id, group_id, name, price, image_id, available, sort_order FROM product_options WHERE id = ?", id).Scan(
+        &option.ID, &option.GroupID, &option.Name, &option.Price, &imageID, &option.Available, &option.SortOrder)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if imageID.Valid {
+        option.ImageID = intPtr(int(imageID.Int64))
+    }
+    return &option, nil
+}
+
+// rowExists UPDATE 影響 0 筆時以 query 確認資料是否存在
+func rowExists(q queryer, query string, id int, res sql.Result) bool {
+    if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+        return true
+    }
+    var exists bool
+    if err := q.QueryRow(query, id).Scan(&exists); err != nil {
+        log.Printf("rowExists error: %v", err)
+        return false
+    }
+    return exists
+}
+
+// InsertOptionGroup 在同一個交易中新增選項群組、套用的商品及選項
+func InsertOptionGroup(group OptionGroup) (int, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec("INSERT INTO product_option_groups (name, min_select, max_select, sort_order) VALUES (?, ?, ?, ?)",
+        group.Name, group.MinSelect, group.MaxSelect, group.SortOrder)
+    if err != nil {
+        return 0, err
+    }
+    groupID, err := res.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+
+    if err := linkOptionGroup(tx, int(groupID), group.ProductIDs); err != nil {
+        return 0, err
+    }
+    for _, option := range group.Options {
+        option.GroupID = int(groupID)
+        if _, err := insertProductOption(tx, option); err != nil {
+            return 0, err
+        }
+    }
+
+    return int(groupID), tx.Commit()
+}
+
+// linkOptionGroup 以 productIDs 取代選項群組套用的商品
+func linkOptionGroup(tx *sql.Tx, groupID int, productIDs []int) error {
+    if _, err := tx.Exec("DELETE FROM product_option_group_links WHERE group_id = ?", groupID); err != nil {
+        return err
+    }
+    for _, productID := range productIDs {
+        _, err := tx.Exec("INSERT IGNORE INTO product_option_group_links (product_id, group_id) VALUES (?, ?)", productID, groupID)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func insertProductOption(q execer, option ProductOption) (int, error) {
+    res, err := q.Exec("INSERT INTO product_options (group_id, name, price, image_id, available, sort_order) VALUES (?, ?, ?, ?, ?, ?)",
+        option.GroupID, option.Name, option.Price, option.ImageID, option.Available, option.SortOrder)
+    if err != nil {
+        return 0, err
+    }
+    id, err := res.LastInsertId()
+    return int(id), err
+}
+
+// FetchProducts 取得所有商品及其選項群組
+func FetchProducts(onlyAvailable bool) ([]Product, error) {
+    query := "SELECT id, name, COALESCE(description, ''), price, image_id, available, sort_order FROM products"
+    if onlyAvailable {
+        query += " WHERE available = 1"
+    }
+    query += " ORDER BY sort_order, id"
+
+    products, err := scanProducts(query)
+    if err != nil {
+        return nil, err
+    }
+
+    groups, err := FetchOptionGroups(0, onlyAvailable)
+    if err != nil {
+        return nil, err
+    }
+    for i := range products {
+        products[i].OptionGroups = groupsForProduct(groups, products[i].ID)
+    }
+    return products, nil
+}
+
+// FetchProduct 取得單一商品及其選項群組 (含停售的選項)，沒有這個商品時回傳 nil
+func FetchProduct(id int) (*Product, error) {
+    products, err := scanProducts("SELECT id, name, COALESCE(description, ''), price, image_id, available, sort_order FROM products WHERE id = ?", id)
+    if err != nil || len(products) == 0 {
+        return nil, err
+    }
+
+    groups, err := FetchOptionGroups(id, false)
+    if err != nil {
+        return nil, err
+    }
+    products[0].OptionGroups = groupsForProduct(groups, id)
+    return &products[0], nil
+}
+
+// fetchProductsByID 一次取得多個商品及其選項群組，以商品 ID 為 key，不存在的商品不會出現在結果中
+func fetchProductsByID(ids []int) (map[int]*Product, error) {
+    result := make(map[int]*Product, len(ids))
+    if len(ids) == 0 {
+        return result, nil
+    }
+
+    args := make([]interface{}, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+    products, err := scanProducts("SELECT id, name, COALESCE(description, ''), price, image_id, available, sort_order FROM products WHERE id IN ("+placeholders+")", args...)
+    if err != nil || len(products) == 0 {
+        return result, err
+    }
+
+    groups, err := FetchOptionGroups(0, false)
+    if err != nil {
+        return nil, err
+    }
+    for i := range products {
+        products[i].OptionGroups = groupsForProduct(groups, products[i].ID)
+        result[products[i].ID] = &products[i]
+    }
+    return result, nil
+}
+
+// mealProductIDs 餐點用到的不重複商品 ID，略過標記刪除的主餐
+func mealProductIDs(meals []*OrderMeal) []int {
+    seen := make(map[int]bool)
+    var ids []int
+    for _, meal := range meals {
+        if meal.MainMeal.Delete || seen[meal.MainMeal.ProductID] {
+            continue
+        }
+        seen[meal.MainMeal.ProductID] = true
+        ids = append(ids, meal.MainMeal.ProductID)
+    }
+    return ids
+}
+
+func scanProducts(query string, args ...interface{}) ([]Product, error) {
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    products := []Product{}
+    for rows.Next() {
+        var product Product
+        var imageID sql.NullInt64
+        if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.Price, &imageID, &product.Available, &product.SortOrder); err != nil {
+            return nil, err
+        }
+        if imageID.Valid {
+            product.ImageID = intPtr(int(imageID.Int64))
+        }
+        products = append(products, product)
+    }
+    return products, rows.Err()
+}
+
+// groupsForProduct 篩選套用在商品上的選項群組
+func groupsForProduct(groups []OptionGroup, productID int) []OptionGroup {
+    result := []OptionGroup{}
+    for _, group := range groups {
+        for _, id := range group.ProductIDs {
+            if id == productID {
+                result = append(result, group)
+                break
+            }
+        }
+    }
+    return result
+}
+
+// FetchOptionGroups 取得選項群組、套用的商品及選項，productID 不為 0 時只取套用在該商品的群組
+func FetchOptionGroups(productID int, onlyAvailable bool) ([]OptionGroup, error) {
+    query := "SELECT id, name, min_select, max_select, sort_order FROM product_option_groups"
+    var args []interface{}
+    if productID != 0 {
+        query += " WHERE id IN (SELECT group_id FROM product_option_group_links WHERE product_id = ?)"
+        args = append(args, productID)
+    }
+    query += " ORDER BY sort_order, id"
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    var groups []OptionGroup
+    index := make(map[int]int)
+    for rows.Next() {
+        group := OptionGroup{ProductIDs: []int{}, Options: []ProductOption{}}
+        if err := rows.Scan(&group.ID, &group.Name, &group.MinSelect, &group.MaxSelect, &group.SortOrder); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        index[group.ID] = len(groups)
+        groups = append(groups, group)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    // 套用的商品
+    links, err := db.Query("SELECT group_id, product_id FROM product_option_group_links ORDER BY product_id")
+    if err != nil {
+        return nil, err
+    }
+    for links.Next() {
+        var groupID, linkedProductID int
+        if err := links.Scan(&groupID, &linkedProductID); err != nil {
+            links.Close()
+            return nil, err
+        }
+        if i, ok := index[groupID]; ok {
+            groups[i].ProductIDs = append(groups[i].ProductIDs, linkedProductID)
+        }
+    }
+    links.Close()
+
+    // 選項
+    optionQuery := "SELECT id, group_id, name, price, image_id, available, sort_order FROM product_options"
+    if onlyAvailable {
+        optionQuery += " WHERE available = 1"
+    }
+    optionQuery += " ORDER BY sort_order, id"
+    options, err := db.Query(optionQuery)
+    if err != nil {
+        return nil, err
+    }
+    defer options.Close()
+    for options.Next() {
+        var option ProductOption
+        var imageID sql.NullInt64
+        if err := options.Scan(&option.ID, &option.GroupID, &option.Name, &option.Price, &imageID, &option.Available, &option.SortOrder); err != nil {
+            return nil, err
+        }
+        if imageID.Valid {
+            option.ImageID = intPtr(int(imageID.Int64))
+        }
+        if i, ok := index[option.GroupID]; ok {
+            groups[i].Options = append(groups[i].Options, option)
+        }
+    }
+
+    return groups, options.Err()
+}
+
+// maxSideMealQuantity 單一附餐選項的數量上限
+const maxSideMealQuantity = 10
+
+// validSideMealQuantity 附餐數量須為 1 到 maxSideMealQuantity 的整數，避免以小數數量降低加價
+func validSideMealQuantity(quantity float64) bool {
+    return quantity == math.Trunc(quantity) && quantity >= 1 && quantity <= maxSideMealQuantity
+}
+
+// applyCatalog 依商品目錄檢查訂單餐點，並把名稱及價格寫入餐點作為下單當時的快照。
+// 附餐的 product_id 為選項 ID，名稱為群組名稱、值為選項名稱。checkGroups 時檢查每個群組的選擇數量。
+func applyCatalog(meals []OrderMeal, checkGroups bool) error {
+    refs := make([]*OrderMeal, len(meals))
+    for i := range meals {
+        refs[i] = &meals[i]
+    }
+    // 每個商品只查詢一次
+    products, err := fetchProductsByID(mealProductIDs(refs))
+    if err != nil {
+        return err
+    }
+
+    for _, meal := range refs {
+        if meal.MainMeal.Delete {
+            continue
+        }
+        // 數量會用在計價及名額，所有建立或修改訂單的路徑都在這裡擋下
+        if meal.MainMeal.Quantity <= 0 {
+            return fmt.Errorf("%w: 主餐數量必須大於 0", errInvalidMeal)
+        }
+
+        product := products[meal.MainMeal.ProductID]
+        if product == nil {
+            return fmt.Errorf("%w: 沒有商品 %d", errInvalidMeal, meal.MainMeal.ProductID)
+        }
+        if !product.Available {
+            return fmt.Errorf("%w: %s 目前不供應", errInvalidMeal, product.Name)
+        }
+        meal.MainMeal.Name = product.Name
+        meal.MainMeal.UnitPrice = product.Price
+
+        options := make(map[int]ProductOption)
+        groups := make(map[int]OptionGroup)
+        for _, group := range product.OptionGroups {
+            groups[group.ID] = group
+            for _, option := range group.Options {
+                options[option.ID] = option
+            }
+        }
+
+        selected := make(map[int]int)
+        for j := range meal.SideMeals {
+            sideMeal := &meal.SideMeals[j]
+            if sideMeal.Delete {
+                continue
+            }
+            option, ok := options[sideMeal.ProductID]
+            if !ok {
+                return fmt.Errorf("%w: %s 沒有選項 %d", errInvalidMeal, product.Name, sideMeal.ProductID)
+            }
+            if !option.Available {
+                return fmt.Errorf("%w: %s 目前不供應", errInvalidMeal, option.Name)
+            }
+            if !validSideMealQuantity(sideMeal.Quantity) {
+                return fmt.Errorf("%w: %s 的數量須為 1 到 %d 的整數", errInvalidMeal, option.Name, maxSideMealQuantity)
+            }
+            sideMeal.Name = groups[option.GroupID].Name
+            sideMeal.Value = option.Name
+            sideMeal.UnitPrice = option.Price
+            selected[option.GroupID]++
+        }
+
+        if checkGroups {
+            if err := checkOptionGroups(product, selected); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// checkOptionGroups 檢查主餐每個選項群組的選擇數量，selected 以群組 ID 為 key
+func checkOptionGroups(product *Product, selected map[int]int) error {
+    for _, group := range product.OptionGroups {
+        count := selected[group.ID]
+        if count < group.MinSelect {
+            return fmt.Errorf("%w: %s 的%s至少需選擇 %d 項", errInvalidMeal, product.Name, group.Name, group.MinSelect)
+        }
+        if group.MaxSelect > 0 && count > group.MaxSelect {
+            return fmt.Errorf("%w: %s 的%s最多只能選擇 %d 項", errInvalidMeal, product.Name, group.Name, group.MaxSelect)
+        }
+    }
+    return nil
+}
+
+// checkMealGroups 以修改後的完整餐點檢查選項群組的選擇數量，用在只送出部分餐點的 PATCH
+func checkMealGroups(meals []*OrderMeal) error {
+    products, err := fetchProductsByID(mealProductIDs(meals))
+    if err != nil {
+        return err
+    }
+
+    for _, meal := range meals {
+        product := products[meal.MainMeal.ProductID]
+        if product == nil {
+            return fmt.Errorf("%w: 沒有商品 %d", errInvalidMeal, meal.MainMeal.ProductID)
+        }
+
+        groupOf := make(map[int]int)
+        for _, group := range product.OptionGroups {
+            for _, option := range group.Options {
+                groupOf[option.ID] = group.ID
+            }
+        }
+        selected := make(map[int]int)
+        for _, sideMeal := range meal.SideMeals {
+            if groupID, ok := groupOf[sideMeal.ProductID]; ok {
+                selected[groupID]++
+            }
+        }
+        if err := checkOptionGroups(product, selected); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,153 @@
+// cron.go
+package api
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// cronSchedule 五欄位 cron 表達式 (分 時 日 月 星期)，每個欄位以位元表示允許的值
+type cronSchedule struct {
+    minute, hour, dom, month, dow uint64
+    domAny, dowAny                bool
+}
+
+type cronField struct {
+    min, max int
+}
+
+var cronFields = []cronField{
+    {0, 59}, // 分
+    {0, 23}, // 時
+    {1, 31}, // 日
+    {1, 12}, // 月
+    {0, 6},  // 星期，0 為星期日
+}
+
+var cronAliases = map[string]string{
+    "@hourly":  "0 * * * *",
+    "@daily":   "0 0 * * *",
+    "@weekly":  "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+}
+
+// parseCron 解析 cron 表達式，支援 *、數字、範圍 (1-5)、列表 (1,3) 及間隔 (*/15)
+func parseCron(expr string) (*cronSchedule, error) {
+    expr = strings.TrimSpace(expr)
+    if alias, ok := cronAliases[expr]; ok {
+        expr = alias
+    }
+
+    parts := strings.Fields(expr)
+    if len(parts) != len(cronFields) {
+        return nil, fmt.Errorf("cron 表達式需要 %d 個欄位: %s", len(cronFields), expr)
+    }
+
+    var bits [5]uint64
+    for i, part := range parts {
+        b, err := parseCronField(part, cronFields[i])
+        if err != nil {
+            return nil, fmt.Errorf("cron 欄位 %q 錯誤: %v", part, err)
+        }
+        bits[i] = b
+    }
+
+    return &cronSchedule{
+        minute: bits[0],
+        hour:   bits[1],
+        dom:    bits[2],
+        month:  bits[3],
+        dow:    bits[4],
+        domAny: parts[2] == "*",
+        dowAny: parts[4] == "*",
+    }, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+    var bits uint64
+    for _, item := range strings.Split(field, ",") {
+        step := 1
+        if i := strings.Index(item, "/"); i >= 0 {
+            var err error
+            step, err = strconv.Atoi(item[i+1:])
+            if err != nil || step <= 0 {
+                return 0, fmt.Errorf("無效的間隔")
+            }
+            item = item[:i]
+        }
+
+        lo, hi := bounds.min, bounds.max
+        if item != "*" {
+            if i := strings.Index(item, "-"); i >= 0 {
+                var err1, err2 error
+                lo, err1 = strconv.Atoi(item[:i])
+                hi, err2 = strconv.Atoi(item[i+1:])
+                if err1 != nil || err2 != nil {
+                    return 0, fmt.Errorf("無效的範圍")
+                }
+            } else {
+                n, err := strconv.Atoi(item)
+                if err != nil {
+                    return 0, fmt.Errorf("無效的數字")
+                }
+                lo = n
+                // 只有單一數字加間隔時 (例如 5/10) 表示從該數字開始到最大值
+                hi = n
+                if step > 1 {
+                    hi = bounds.max
+                }
+            }
+        }
+        if lo < bounds.min || hi > bounds.max || lo > hi {
+            return 0, fmt.Errorf("超出範圍 %d-%d", bounds.min, bounds.max)
+        }
+
+        for v := lo; v <= hi; v += step {
+            bits |= 1 << uint(v)
+        }
+    }
+    return bits, nil
+}
+
+func cronMatch(bits uint64, v int) bool {
+    return bits&(1<<uint(v)) != 0
+}
+
+// dayMatch 日與星期都有指定時任一符合即可，與標準 cron 相同
+func (s *cronSchedule) dayMatch(t time.Time) bool {
+    domOK := cronMatch(s.dom, t.Day())
+    dowOK := cronMatch(s.dow, int(t.Weekday()))
+    if s.domAny || s.dowAny {
+        return domOK && dowOK
+    }
+    return domOK || dowOK
+}
+
+// Next 回傳 t 之後下一個符合的時間，五年內都找不到時回傳零值
+func (s *cronSchedule) Next(t time.Time) time.Time {
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0)
+
+    for t.Before(limit) {
+        if !cronMatch(s.month, int(t.Month())) {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if !s.dayMatch(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if !cronMatch(s.hour, t.Hour()) {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+            continue
+        }
+        if !cronMatch(s.minute, t.Minute()) {
+            t = t.Add(time.Minute)
+            continue
+        }
+        return t
+    }
+    return time.Time{}
+}
@@ -0,0 +1,157 @@
+// cutoff.go
+package api
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+    _ "time/tzdata" // alpine 映像檔沒有時區資料
+
+    "github.com/gin-gonic/gin"
+)
+
+var errPastCutoff = errors.New("已超過該時段的下單截止時間")
+
+// businessLocation 截止時間以營業所在時區計算，可用環境變數 APP_TIMEZONE 指定
+func businessLocation() *time.Location {
+    name := os.Getenv("APP_TIMEZONE")
+    if name == "" {
+        name = "Asia/Taipei"
+    }
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        log.Printf("無效的時區 %s: %v", name, err)
+        return time.Local
+    }
+    return loc
+}
+
+// GetCutoffs 取得據點各時段的截止規則
+func GetCutoffs(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    rules, err := FetchCutoffs(locationID)
+    if err != nil {
+        log.Printf("FetchCutoffs error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取截止規則"})
+        return
+    }
+
+    cutoffs := []SlotCutoff{}
+    for _, rule := range rules {
+        cutoffs = append(cutoffs, rule)
+    }
+    c.JSON(http.StatusOK, cutoffs)
+}
+
+// SetCutoffs 新增或更新時段的截止規則
+func SetCutoffs(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    var cutoffs []SlotCutoff
+    if err := c.ShouldBindJSON(&cutoffs); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    for _, cutoff := range cutoffs {
+        if _, err := time.Parse("15:04", cutoff.CutoffTime); err != nil || cutoff.TimeSlot == "" || cutoff.DaysBefore < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "無效的截止規則", "cutoff": cutoff})
+            return
+        }
+    }
+
+    for _, cutoff := range cutoffs {
+        _, err := db.Exec("INSERT INTO SlotCutoffs (LocationID, TimeSlot, DaysBefore, CutoffTime) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE DaysBefore = VALUES(DaysBefore), CutoffTime = VALUES(CutoffTime)",
+            locationID, cutoff.TimeSlot, cutoff.DaysBefore, cutoff.CutoffTime)
+        if err != nil {
+            log.Printf("SetCutoffs error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+            return
+        }
+    }
+
+    c.JSON(http.StatusOK, gin.H{"result": "更新成功"})
+}
+
+// DeleteCutoff 刪除時段的截止規則，恢復為時段開始時截止
+func DeleteCutoff(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    _, err = db.Exec("DELETE FROM SlotCutoffs WHERE LocationID = ? AND TimeSlot = ?", locationID, c.Query("time_slot"))
+    if err != nil {
+        log.Printf("DeleteCutoff error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"result": "刪除成功"})
+}
+
+// FetchCutoffs 取得據點的截止規則，以時段為 key
+func FetchCutoffs(locationID int) (map[string]SlotCutoff, error) {
+    rows, err := db.Query("SELECT LocationID, TimeSlot, DaysBefore, TIME_FORMAT(CutoffTime, '%H:%i') FROM SlotCutoffs WHERE LocationID = ?", locationID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    rules := make(map[string]SlotCutoff)
+    for rows.Next() {
+        var rule SlotCutoff
+        if err := rows.Scan(&rule.LocationID, &rule.TimeSlot, &rule.DaysBefore, &rule.CutoffTime); err != nil {
+            return nil, err
+        }
+        rules[rule.TimeSlot] = rule
+    }
+
+    return rules, rows.Err()
+}
+
+// cutoffFor 計算某日期時段的截止時間，沒有規則時以時段開始時間 (例如 "11:00-12:00" 的 11:00) 截止，
+// 無法判斷時回傳 false
+func cutoffFor(rules map[string]SlotCutoff, date, timeSlot string) (time.Time, bool) {
+    loc := businessLocation()
+    day, err := time.ParseInLocation("2006-01-02", date, loc)
+    if err != nil {
+        return time.Time{}, false
+    }
+
+    daysBefore := 0
+    clock := strings.TrimSpace(strings.SplitN(timeSlot, "-", 2)[0])
+    if rule, ok := rules[timeSlot]; ok {
+        daysBefore = rule.DaysBefore
+        clock = rule.CutoffTime
+    }
+
+    t, err := time.Parse("15:04", clock)
+    if err != nil {
+        return time.Time{}, false
+    }
+    return time.Date(day.Year(), day.Month(), day.Day()-daysBefore, t.Hour(), t.Minute(), 0, 0, loc), true
+}
+
+// checkCutoff 超過截止時間時回傳 errPastCutoff
+func checkCutoff(locationID int, date, timeSlot string) error {
+    rules, err := FetchCutoffs(locationID)
+    if err != nil {
+        return err
+    }
+    if cutoffAt, ok := cutoffFor(rules, date, timeSlot); ok && !time.Now().Before(cutoffAt) {
+        return errPastCutoff
+    }
+    return nil
+}
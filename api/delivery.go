@@ -4,16 +4,14 @@ package api
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 // GetDeliveryByOrderCode 根據訂單編號查外送員
 func GetDeliveryByOrderCode(c *gin.Context) {
-    orderCodeStr := c.Param("order_code")
-    orderCode, err := strconv.Atoi(orderCodeStr)
-    if err != nil {
+    orderCode := c.Param("order_code")
+    if !validOrderCode(orderCode) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "無此訂單編號"})
         return
     }
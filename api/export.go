@@ -0,0 +1,204 @@
+// export.go
+package api
+
+import (
+    "encoding/csv"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 訂閱行事曆時沒有指定範圍，預設匯出前 7 天到後 60 天
+const (
+    exportDaysBefore = 7
+    exportDaysAfter  = 60
+)
+
+// CalendarSlot 表示匯出的某日期時段，上限為 0 表示公休
+type CalendarSlot struct {
+    Date     string
+    TimeSlot string
+    Limit    int
+    Booked   int
+}
+
+// FetchCalendarSlots 取得據點日期範圍內 (含頭尾) 所有時段的上限及已訂份數
+func FetchCalendarSlots(locationID int, startDate, endDate string) ([]CalendarSlot, error) {
+    rows, err := db.Query(`SELECT DATE_FORMAT(l.Date, '%Y-%m-%d'), l.TimeSlot, l.LimitCount,
+            (SELECT COALESCE(SUM(op.quantity), 0) FROM orders o JOIN order_products op ON op.order_id = o.id
+                WHERE o.location_id = l.LocationID AND o.delivery_date = l.Date
+                AND o.delivery_time_range = l.TimeSlot AND o.status_code NOT IN (` + releasedStatuses + `))
+        FROM DateLimits l WHERE l.LocationID = ? AND l.Date BETWEEN ? AND ? ORDER BY l.Date, l.TimeSlot`,
+        locationID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var slots []CalendarSlot
+    for rows.Next() {
+        var slot CalendarSlot
+        if err := rows.Scan(&slot.Date, &slot.TimeSlot, &slot.Limit, &slot.Booked); err != nil {
+            return nil, err
+        }
+        slots = append(slots, slot)
+    }
+
+    return slots, rows.Err()
+}
+
+// exportRange 讀取匯出的據點及日期範圍，沒有指定日期時使用預設範圍
+func exportRange(c *gin.Context) (int, string, string, error) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        return 0, "", "", err
+    }
+
+    startDate, endDate := c.Query("start_date"), c.Query("end_date")
+    today := time.Now().In(businessLocation())
+    if startDate == "" {
+        startDate = today.AddDate(0, 0, -exportDaysBefore).Format("2006-01-02")
+    }
+    if endDate == "" {
+        endDate = today.AddDate(0, 0, exportDaysAfter).Format("2006-01-02")
+    }
+    if _, _, err := parseDateRange(startDate, endDate); err != nil {
+        return 0, "", "", err
+    }
+    return locationID, startDate, endDate, nil
+}
+
+// ExportCapacityICS 以 iCalendar 格式匯出時段上限，每個開放的時段一個事件，公休的時段不匯出
+func ExportCapacityICS(c *gin.Context) {
+    locationID, startDate, endDate, err := exportRange(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    slots, err := FetchCalendarSlots(locationID, startDate, endDate)
+    if err != nil {
+        log.Printf("FetchCalendarSlots error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法匯出行事曆"})
+        return
+    }
+
+    var b strings.Builder
+    stamp := time.Now().UTC().Format("20060102T150405Z")
+    writeICSLine(&b, "BEGIN:VCALENDAR")
+    writeICSLine(&b, "VERSION:2.0")
+    writeICSLine(&b, "PRODID:-//delivery-capacity//EN")
+    writeICSLine(&b, "CALSCALE:GREGORIAN")
+    writeICSLine(&b, "X-WR-CALNAME:"+escapeICS(fmt.Sprintf("據點 %d 外送時段", locationID)))
+    for _, slot := range slots {
+        if slot.Limit <= 0 {
+            continue
+        }
+
+        writeICSLine(&b, "BEGIN:VEVENT")
+        writeICSLine(&b, "UID:"+escapeICS(fmt.Sprintf("%d-%s-%s@delivery-capacity", locationID, slot.Date, slot.TimeSlot)))
+        writeICSLine(&b, "DTSTAMP:"+stamp)
+        if start, end, ok := slotTimes(slot.Date, slot.TimeSlot); ok {
+            writeICSLine(&b, "DTSTART:"+start.UTC().Format("20060102T150405Z"))
+            writeICSLine(&b, "DTEND:"+end.UTC().Format("20060102T150405Z"))
+        } else {
+            // 無法解析時段時以整天事件表示
+            day, _ := time.Parse("2006-01-02", slot.Date)
+            writeICSLine(&b, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+            writeICSLine(&b, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+        }
+        writeICSLine(&b, "SUMMARY:"+escapeICS(fmt.Sprintf("%s 已訂 %d/%d", slot.TimeSlot, slot.Booked, slot.Limit)))
+        writeICSLine(&b, "DESCRIPTION:"+escapeICS(fmt.Sprintf("上限: %d\n已訂: %d\n剩餘: %d", slot.Limit, slot.Booked, slot.Limit-slot.Booked)))
+        writeICSLine(&b, "END:VEVENT")
+    }
+    writeICSLine(&b, "END:VCALENDAR")
+
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=capacity-%d.ics", locationID))
+    c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(b.String()))
+}
+
+// ExportCapacityCSV 以 CSV 匯出時段上限，公休的時段標示 closed
+func ExportCapacityCSV(c *gin.Context) {
+    locationID, startDate, endDate, err := exportRange(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    slots, err := FetchCalendarSlots(locationID, startDate, endDate)
+    if err != nil {
+        log.Printf("FetchCalendarSlots error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法匯出 CSV"})
+        return
+    }
+
+    c.Header("Content-Type", "text/csv; charset=utf-8")
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=capacity-%d.csv", locationID))
+    c.Status(http.StatusOK)
+
+    // 加上 BOM 讓 Excel 正確辨識 UTF-8
+    c.Writer.WriteString("\xEF\xBB\xBF")
+    w := csv.NewWriter(c.Writer)
+    w.Write([]string{"location_id", "date", "time_slot", "limit", "booked", "available", "closed"})
+    for _, slot := range slots {
+        available := slot.Limit - slot.Booked
+        if available < 0 {
+            available = 0
+        }
+        w.Write([]string{
+            strconv.Itoa(locationID),
+            slot.Date,
+            slot.TimeSlot,
+            strconv.Itoa(slot.Limit),
+            strconv.Itoa(slot.Booked),
+            strconv.Itoa(available),
+            strconv.FormatBool(slot.Limit <= 0),
+        })
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        log.Printf("ExportCapacityCSV error: %v", err)
+    }
+}
+
+// slotTimes 將 "11:00-12:00" 形式的時段轉成營業時區的開始及結束時間
+func slotTimes(date, timeSlot string) (time.Time, time.Time, bool) {
+    parts := strings.SplitN(timeSlot, "-", 2)
+    if len(parts) != 2 {
+        return time.Time{}, time.Time{}, false
+    }
+    loc := businessLocation()
+    start, err1 := time.ParseInLocation("2006-01-02 15:04", date+" "+strings.TrimSpace(parts[0]), loc)
+    end, err2 := time.ParseInLocation("2006-01-02 15:04", date+" "+strings.TrimSpace(parts[1]), loc)
+    if err1 != nil || err2 != nil || !end.After(start) {
+        return time.Time{}, time.Time{}, false
+    }
+    return start, end, true
+}
+
+// escapeICS 跳脫 iCalendar 文字中的特殊字元
+func escapeICS(s string) string {
+    r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+    return r.Replace(s)
+}
+
+// writeICSLine 寫入一行並依規範在 75 bytes 處折行，不切斷 UTF-8 字元
+func writeICSLine(b *strings.Builder, line string) {
+    // 折行後的續行以空白開頭，也算在 75 bytes 內
+    limit := 75
+    for len(line) > limit {
+        cut := limit
+        for cut > 0 && line[cut]&0xC0 == 0x80 {
+            cut--
+        }
+        b.WriteString(line[:cut] + "\r\n ")
+        line = line[cut:]
+        limit = 74
+    }
+    b.WriteString(line + "\r\n")
+}
@@ -0,0 +1,251 @@
+// forecast.go
+package api
+
+import (
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 預測參考的週數、每往前一週權重衰減的比例，以及建議上限保留的餘裕
+const (
+    defaultForecastWeeks = 8
+    maxForecastWeeks     = 52
+    forecastDecay        = 0.7
+    forecastHeadroom     = 1.1
+)
+
+// LimitSuggestion 表示某日期時段的建議上限
+type LimitSuggestion struct {
+    Date      string  `json:"date"`
+    TimeSlot  string  `json:"time_slot"`
+    Expected  float64 `json:"expected"`  // 預估訂購份數
+    Suggested int     `json:"suggested"` // 預估份數加上餘裕
+    Samples   int     `json:"samples"`   // 參考的歷史日期數，0 表示沒有資料或沒有訂單而使用預設模板
+    Current   *int    `json:"current"`   // 目前設定的上限，沒有設定時為 null
+}
+
+// forecastModel 依星期及時段的季節性模型：同星期同時段的歷史份數加權平均，越近的週權重越高
+type forecastModel struct {
+    expected map[time.Weekday]map[string]float64
+    samples  map[time.Weekday]map[string]int
+    template map[string]int
+}
+
+// buildForecastModel 讀取 asOf 之前 weeks 週內有開放的時段及其已訂份數建立模型
+func buildForecastModel(locationID int, asOf time.Time, weeks int) (*forecastModel, error) {
+    templateLimits, err := FetchTimeSlotLimits(locationID)
+    if err != nil {
+        return nil, err
+    }
+    model := &forecastModel{
+        expected: make(map[time.Weekday]map[string]float64),
+        samples:  make(map[time.Weekday]map[string]int),
+        template: make(map[string]int),
+    }
+    for _, limit := range templateLimits {
+        model.template[limit.TimeSlot] = limit.LimitCount
+    }
+
+    asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+    history, err := fetchBookedHistory(locationID, asOf.AddDate(0, 0, -7*weeks).Format("2006-01-02"), asOf.Format("2006-01-02"))
+    if err != nil {
+        return nil, err
+    }
+
+    weightSums := make(map[time.Weekday]map[string]float64)
+    for _, h := range history {
+        date, err := time.Parse("2006-01-02", h.Date)
+        if err != nil {
+            continue
+        }
+        weekday := date.Weekday()
+        weeksAgo := int(asOf.Sub(date).Hours()/24) / 7
+        weight := math.Pow(forecastDecay, float64(weeksAgo))
+
+        if _, ok := model.expected[weekday]; !ok {
+            model.expected[weekday] = make(map[string]float64)
+            model.samples[weekday] = make(map[string]int)
+            weightSums[weekday] = make(map[string]float64)
+        }
+        model.expected[weekday][h.TimeSlot] += weight * float64(h.Booked)
+        model.samples[weekday][h.TimeSlot]++
+        weightSums[weekday][h.TimeSlot] += weight
+    }
+    for weekday, slots := range model.expected {
+        for timeSlot := range slots {
+            slots[timeSlot] /= weightSums[weekday][timeSlot]
+        }
+    }
+
+    return model, nil
+}
+
+// suggest 回傳某日期時段的預估份數、建議上限及參考的樣本數。沒有歷史資料或歷史上都沒有訂單時
+// 無從預測，樣本數回傳 0 並使用預設模板，不會因為沒人訂就把時段關閉；有預測時建議上限至少為 1
+func (m *forecastModel) suggest(date time.Time, timeSlot string) (float64, int, int) {
+    samples := m.samples[date.Weekday()][timeSlot]
+    expected := m.expected[date.Weekday()][timeSlot]
+    if samples == 0 || expected <= 0 {
+        return 0, m.template[timeSlot], 0
+    }
+    suggested := int(math.Ceil(expected * forecastHeadroom))
+    if suggested < 1 {
+        suggested = 1
+    }
+    return expected, suggested, samples
+}
+
+// timeSlots 模型涵蓋的時段：預設模板加上歷史出現過的時段
+func (m *forecastModel) timeSlots() []string {
+    seen := make(map[string]bool)
+    for timeSlot := range m.template {
+        seen[timeSlot] = true
+    }
+    for _, slots := range m.samples {
+        for timeSlot := range slots {
+            seen[timeSlot] = true
+        }
+    }
+
+    timeSlots := make([]string, 0, len(seen))
+    for timeSlot := range seen {
+        timeSlots = append(timeSlots, timeSlot)
+    }
+    sort.Strings(timeSlots)
+    return timeSlots
+}
+
+// bookedHistory 表示過去某日期時段的已訂份數
+type bookedHistory struct {
+    Date     string
+    TimeSlot string
+    Booked   int
+}
+
+// fetchBookedHistory 取得日期範圍內 (不含結束日) 有開放的時段及非作廢訂單的份數，沒有訂單的時段為 0。
+// 已封存的日期從封存表讀取。
+func fetchBookedHistory(locationID int, startDate, endDate string) ([]bookedHistory, error) {
+    rows, err := db.Query(`SELECT DATE_FORMAT(l.Date, '%Y-%m-%d'), l.TimeSlot,
+            (SELECT COALESCE(SUM(op.quantity), 0) FROM orders o JOIN order_products op ON op.order_id = o.id
+                WHERE o.location_id = l.LocationID AND o.delivery_date = l.Date
+                AND o.delivery_time_range = l.TimeSlot AND o.status_code NOT IN (` + releasedStatuses + `))
+        FROM DateLimits l WHERE l.LocationID = ? AND l.Date >= ? AND l.Date < ? AND l.LimitCount > 0
+        UNION ALL
+        SELECT DATE_FORMAT(a.Date, '%Y-%m-%d'), a.TimeSlot, a.Booked
+        FROM DateLimitArchive a WHERE a.LocationID = ? AND a.Date >= ? AND a.Date < ? AND a.LimitCount > 0`,
+        locationID, startDate, endDate, locationID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var history []bookedHistory
+    for rows.Next() {
+        var h bookedHistory
+        if err := rows.Scan(&h.Date, &h.TimeSlot, &h.Booked); err != nil {
+            return nil, err
+        }
+        history = append(history, h)
+    }
+
+    return history, rows.Err()
+}
+
+// ForecastLimits 依歷史訂單為日期範圍 (含頭尾) 內的每個時段建議上限
+func ForecastLimits(locationID int, startDate, endDate string, weeks int) ([]LimitSuggestion, error) {
+    start, end, err := parseDateRange(startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+
+    model, err := buildForecastModel(locationID, time.Now(), weeks)
+    if err != nil {
+        return nil, err
+    }
+    current, err := fetchDateLimitRows(locationID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+
+    suggestions := []LimitSuggestion{}
+    timeSlots := model.timeSlots()
+    for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+        dateStr := d.Format("2006-01-02")
+        for _, timeSlot := range timeSlots {
+            expected, suggested, samples := model.suggest(d, timeSlot)
+            suggestion := LimitSuggestion{
+                Date:      dateStr,
+                TimeSlot:  timeSlot,
+                Expected:  math.Round(expected*10) / 10,
+                Suggested: suggested,
+                Samples:   samples,
+            }
+            if row, ok := current[dateStr][timeSlot]; ok {
+                suggestion.Current = intPtr(row.LimitCount)
+            }
+            suggestions = append(suggestions, suggestion)
+        }
+    }
+
+    return suggestions, nil
+}
+
+// parseForecastWeeks 從查詢參數 weeks 讀取參考週數，預設 8 週
+func parseForecastWeeks(c *gin.Context) (int, error) {
+    weeksStr := c.Query("weeks")
+    if weeksStr == "" {
+        return defaultForecastWeeks, nil
+    }
+    weeks, err := strconv.Atoi(weeksStr)
+    if err != nil || weeks <= 0 || weeks > maxForecastWeeks {
+        return 0, fmt.Errorf("參考週數需介於 1 到 %d", maxForecastWeeks)
+    }
+    return weeks, nil
+}
+
+// GetCapacityForecast 取得日期範圍內各時段的建議上限
+func GetCapacityForecast(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    weeks, err := parseForecastWeeks(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    startDate := c.Query("start_date")
+    endDate := c.Query("end_date")
+    if _, _, err := parseDateRange(startDate, endDate); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    suggestions, err := ForecastLimits(locationID, startDate, endDate, weeks)
+    if err != nil {
+        log.Printf("ForecastLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生建議上限"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "weeks":       weeks,
+        "headroom":    forecastHeadroom,
+        "suggestions": suggestions,
+    })
+}
+
+// autoAddUseForecast 環境變數 AUTO_ADD_FORECAST=true 時排程自動新增改用預測的建議上限
+func autoAddUseForecast() bool {
+    return os.Getenv("AUTO_ADD_FORECAST") == "true"
+}
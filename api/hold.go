@@ -0,0 +1,400 @@
+// hold.go
+package api
+
+import (
+    "context"
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+var (
+    errHoldInvalid  = errors.New("保留憑證無效或已過期")
+    errTooManyHolds = errors.New("保留中的名額過多，請先完成或取消結帳")
+)
+
+// 預設保留名額的時間
+const defaultHoldTTL = 10 * time.Minute
+
+// holdTTL 從環境變數 HOLD_TTL_MINUTES 讀取保留時間
+func holdTTL() time.Duration {
+    minutes, err := strconv.Atoi(os.Getenv("HOLD_TTL_MINUTES"))
+    if err != nil || minutes <= 0 {
+        return defaultHoldTTL
+    }
+    return time.Duration(minutes) * time.Minute
+}
+
+// 預設每次保留的份數上限及每個用戶端同時保留的數量上限
+const (
+    defaultMaxHoldQuantity   = 20
+    defaultMaxHoldsPerClient = 3
+)
+
+// maxHoldQuantity 從環境變數 HOLD_MAX_QUANTITY 讀取每次保留的份數上限
+func maxHoldQuantity() int {
+    n, err := strconv.Atoi(os.Getenv("HOLD_MAX_QUANTITY"))
+    if err != nil || n <= 0 {
+        return defaultMaxHoldQuantity
+    }
+    return n
+}
+
+// maxHoldsPerClient 從環境變數 HOLD_MAX_PER_CLIENT 讀取同一個 IP 同時保留的數量上限
+func maxHoldsPerClient() int {
+    n, err := strconv.Atoi(os.Getenv("HOLD_MAX_PER_CLIENT"))
+    if err != nil || n <= 0 {
+        return defaultMaxHoldsPerClient
+    }
+    return n
+}
+
+// CreateHold 結帳前暫時保留某日期時段的名額，回傳保留憑證
+func CreateHold(c *gin.Context) {
+    var hold CapacityHold
+    if err := c.ShouldBindJSON(&hold); err != nil || hold.Date == "" || hold.TimeSlot == "" || hold.Quantity <= 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if _, err := time.Parse("2006-01-02", hold.Date); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式錯誤"})
+        return
+    }
+    if max := maxHoldQuantity(); hold.Quantity > max {
+        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("每次最多保留 %d 份", max)})
+        return
+    }
+    if hold.LocationID == 0 {
+        hold.LocationID = defaultLocationID
+    }
+    hold.ClientIP = c.ClientIP()
+
+    if err := checkCutoff(hold.LocationID, hold.Date, hold.TimeSlot); err != nil {
+        if err == errPastCutoff {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        log.Printf("checkCutoff error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法保留名額"})
+        return
+    }
+
+    hold, err := InsertHold(hold)
+    if err == errSlotFull {
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    }
+    if err == errTooManyHolds {
+        c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("InsertHold error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法保留名額"})
+        return
+    }
+
+    c.JSON(http.StatusCreated, hold)
+}
+
+// ReleaseHoldHandler 取消結帳時釋放保留的名額
+func ReleaseHoldHandler(c *gin.Context) {
+    hold, err := FetchActiveHold(c.Param("token"))
+    if err != nil {
+        log.Printf("FetchActiveHold error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法釋放名額"})
+        return
+    }
+    if hold == nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": errHoldInvalid.Error()})
+        return
+    }
+
+    if _, err := ReleaseHold(hold.Token); err != nil {
+        log.Printf("ReleaseHold error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法釋放名額"})
+        return
+    }
+
+    // 釋放的是候補名額時標記為過期，名額再提供給下一位
+    if err := expireWaitlistOffers(); err != nil {
+        log.Printf("expireWaitlistOffers error: %v", err)
+    }
+    capacityFreed(hold.LocationID, hold.Date, hold.TimeSlot)
+
+    c.JSON(http.StatusOK, gin.H{"message": "已釋放保留名額"})
+}
+
+// GetAvailability 取得據點某日期各時段的剩餘名額 (已扣除保留中的名額)，
+// 已超過截止時間的時段不顯示，管理員可用 include_closed=true 查看全部
+func GetAvailability(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    date := c.Query("date")
+    if date == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供日期"})
+        return
+    }
+
+    availability, err := FetchAvailability(locationID, date)
+    if err != nil {
+        log.Printf("FetchAvailability error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取剩餘名額"})
+        return
+    }
+
+    if c.Query("include_closed") == "true" && isAdminRequest(c) {
+        c.JSON(http.StatusOK, availability)
+        return
+    }
+
+    now := time.Now()
+    open := []SlotAvailability{}
+    for _, slot := range availability {
+        if slot.CutoffAt != "" {
+            cutoffAt, err := time.Parse(time.RFC3339, slot.CutoffAt)
+            if err == nil && !now.Before(cutoffAt) {
+                continue
+            }
+        }
+        open = append(open, slot)
+    }
+    c.JSON(http.StatusOK, open)
+}
+
+// newHoldToken 產生隨機的保留憑證
+func newHoldToken() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// InsertHold 在交易中鎖定時段並確認名額足夠後新增保留
+func InsertHold(hold CapacityHold) (CapacityHold, error) {
+    return insertHold(hold, holdTTL())
+}
+
+func insertHold(hold CapacityHold, ttl time.Duration) (CapacityHold, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return hold, err
+    }
+    defer tx.Rollback()
+
+    hold, err = insertHoldTx(tx, hold, ttl)
+    if err != nil {
+        return hold, err
+    }
+    return hold, tx.Commit()
+}
+
+// insertHoldTx 在呼叫端的交易中新增保留，由呼叫端提交
+func insertHoldTx(tx *sql.Tx, hold CapacityHold, ttl time.Duration) (CapacityHold, error) {
+    // 同一個用戶端尚未過期的保留數量，鎖定避免同時建立超過上限
+    if hold.ClientIP != "" {
+        var active int
+        err := tx.QueryRow("SELECT COUNT(*) FROM CapacityHolds WHERE ClientIP = ? AND Status = 'active' AND ExpiresAt > ? FOR UPDATE",
+            hold.ClientIP, time.Now()).Scan(&active)
+        if err != nil {
+            return hold, err
+        }
+        if active >= maxHoldsPerClient() {
+            return hold, errTooManyHolds
+        }
+    }
+
+    // 鎖定時段避免同時保留最後的名額
+    limit, limited, err := lockSlotCapacity(tx, hold.LocationID, hold.Date, hold.TimeSlot)
+    if err != nil {
+        return hold, err
+    }
+
+    if limited {
+        booked, err := bookedUnits(tx, hold.LocationID, hold.Date, hold.TimeSlot)
+        if err != nil {
+            return hold, err
+        }
+        held, err := heldUnits(tx, hold.LocationID, hold.Date, hold.TimeSlot, "")
+        if err != nil {
+            return hold, err
+        }
+        if booked+held+hold.Quantity > limit {
+            return hold, errSlotFull
+        }
+    }
+
+    hold.Token, err = newHoldToken()
+    if err != nil {
+        return hold, err
+    }
+    hold.ExpiresAt = time.Now().Add(ttl)
+
+    _, err = tx.Exec("INSERT INTO CapacityHolds (Token, LocationID, Date, TimeSlot, Quantity, ExpiresAt, Status, ClientIP) VALUES (?, ?, ?, ?, ?, ?, 'active', ?)",
+        hold.Token, hold.LocationID, hold.Date, hold.TimeSlot, hold.Quantity, hold.ExpiresAt, hold.ClientIP)
+    return hold, err
+}
+
+// heldUnits 統計時段內尚未過期的保留名額，excludeToken 用來排除訂單自己的保留
+func heldUnits(q queryer, locationID int, date, timeSlot, excludeToken string) (int, error) {
+    var held int
+    err := q.QueryRow(`SELECT COALESCE(SUM(Quantity), 0) FROM CapacityHolds
+        WHERE LocationID = ? AND Date = ? AND TimeSlot = ? AND Status = 'active' AND ExpiresAt > ? AND Token <> ?`,
+        locationID, date, timeSlot, time.Now(), excludeToken).Scan(&held)
+    return held, err
+}
+
+// FetchActiveHold 取得尚未過期的保留，不存在或已失效時回傳 nil
+func FetchActiveHold(token string) (*CapacityHold, error) {
+    var hold CapacityHold
+    var expiresAtString string
+    err := db.QueryRow("SELECT Token, LocationID, Date, TimeSlot, Quantity, ExpiresAt FROM CapacityHolds WHERE Token = ? AND Status = 'active' AND ExpiresAt > ?",
+        token, time.Now()).Scan(&hold.Token, &hold.LocationID, &hold.Date, &hold.TimeSlot, &hold.Quantity, &expiresAtString)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    hold.ExpiresAt, err = time.Parse("2006-01-02 15:04:05", expiresAtString)
+    if err != nil {
+        return nil, err
+    }
+    return &hold, nil
+}
+
+// ConvertHold 訂單建立後將保留轉為正式訂單，保留已過期或已被釋放時回傳 errHoldInvalid 讓交易取消
+func ConvertHold(q execer, token string, orderID int64) error {
+    res, err := q.Exec("UPDATE CapacityHolds SET Status = 'converted', OrderID = ? WHERE Token = ? AND Status = 'active' AND ExpiresAt > ?", orderID, token, time.Now())
+    if err != nil {
+        return err
+    }
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if affected != 1 {
+        return errHoldInvalid
+    }
+    return acceptWaitlistOffer(q, token)
+}
+
+// ReleaseHold 釋放保留的名額，沒有可釋放的保留時回傳 false
+func ReleaseHold(token string) (bool, error) {
+    res, err := db.Exec("UPDATE CapacityHolds SET Status = 'released' WHERE Token = ? AND Status = 'active'", token)
+    if err != nil {
+        return false, err
+    }
+    affected, err := res.RowsAffected()
+    return affected > 0, err
+}
+
+// ExpireHolds 將過期的保留標記為 expired，回傳有名額釋出的時段
+func ExpireHolds() ([]CapacityHold, error) {
+    now := time.Now()
+    rows, err := db.Query("SELECT DISTINCT LocationID, Date, TimeSlot FROM CapacityHolds WHERE Status = 'active' AND ExpiresAt <= ?", now)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var slots []CapacityHold
+    for rows.Next() {
+        var slot CapacityHold
+        if err := rows.Scan(&slot.LocationID, &slot.Date, &slot.TimeSlot); err != nil {
+            return nil, err
+        }
+        slots = append(slots, slot)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    _, err = db.Exec("UPDATE CapacityHolds SET Status = 'expired' WHERE Status = 'active' AND ExpiresAt <= ?", now)
+    if err != nil {
+        return nil, err
+    }
+    return slots, nil
+}
+
+// sweepExpiredHolds 由排程每分鐘執行，釋放過期的保留並把名額提供給候補，回傳執行摘要
+func sweepExpiredHolds(ctx context.Context) (string, error) {
+    slots, err := ExpireHolds()
+    if err != nil {
+        return "", err
+    }
+    if len(slots) == 0 {
+        return "no expired holds", nil
+    }
+
+    if err := expireWaitlistOffers(); err != nil {
+        log.Printf("expireWaitlistOffers error: %v", err)
+    }
+    for _, slot := range slots {
+        // 保留已釋放，ctx 取消時只略過通知候補，下次執行仍會提供給候補
+        if err := ctx.Err(); err != nil {
+            return fmt.Sprintf("released holds in %d slots", len(slots)), err
+        }
+        capacityFreed(slot.LocationID, slot.Date, slot.TimeSlot)
+    }
+    return fmt.Sprintf("released holds in %d slots", len(slots)), nil
+}
+
+// FetchAvailability 計算據點某日期各時段的上限、已訂、保留中及剩餘名額
+func FetchAvailability(locationID int, date string) ([]SlotAvailability, error) {
+    rows, err := db.Query("SELECT TimeSlot, LimitCount FROM DateLimits WHERE LocationID = ? AND Date = ? ORDER BY TimeSlot", locationID, date)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var slots []SlotAvailability
+    for rows.Next() {
+        var slot SlotAvailability
+        if err := rows.Scan(&slot.TimeSlot, &slot.Limit); err != nil {
+            return nil, err
+        }
+        slots = append(slots, slot)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    rules, err := FetchCutoffs(locationID)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range slots {
+        slot := &slots[i]
+        if cutoffAt, ok := cutoffFor(rules, date, slot.TimeSlot); ok {
+            slot.CutoffAt = cutoffAt.Format(time.RFC3339)
+        }
+        if slot.Booked, err = bookedUnits(db, locationID, date, slot.TimeSlot); err != nil {
+            return nil, err
+        }
+        if slot.Held, err = heldUnits(db, locationID, date, slot.TimeSlot, ""); err != nil {
+            return nil, err
+        }
+        slot.Available = slot.Limit - slot.Booked - slot.Held
+        if slot.Available < 0 {
+            slot.Available = 0
+        }
+    }
+
+    return slots, nil
+}
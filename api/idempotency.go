@@ -0,0 +1,197 @@
+// idempotency.go
+package api
+
+import (
+    "context"
+    "bytes"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 預設保存回應的時間
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// Idempotency-Key 的最大長度
+const maxIdempotencyKeyLength = 255
+
+// 保存回應失敗時重試的次數
+const idempotencySaveAttempts = 3
+
+// idempotencyUpstreamSent 處理函式已把請求送往外部服務時設定，之後即使失敗也不釋出 key，避免重試時重複送出
+const idempotencyUpstreamSent = "idempotencyUpstreamSent"
+
+// markUpstreamSent 標記請求已送往外部服務
+func markUpstreamSent(c *gin.Context) {
+    c.Set(idempotencyUpstreamSent, true)
+}
+
+// idempotencyTTL 從環境變數 IDEMPOTENCY_TTL_HOURS 讀取保存時間
+func idempotencyTTL() time.Duration {
+    hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS"))
+    if err != nil || hours <= 0 {
+        return defaultIdempotencyTTL
+    }
+    return time.Duration(hours) * time.Hour
+}
+
+// idempotencyRecord 已保存的請求及回應
+type idempotencyRecord struct {
+    RequestHash    string
+    ResponseStatus sql.NullInt64
+    ContentType    string
+    ResponseBody   []byte
+}
+
+// recordingWriter 轉發回應的同時記錄內容，供之後回放
+type recordingWriter struct {
+    gin.ResponseWriter
+    body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+    w.body.Write(data)
+    return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+    w.body.WriteString(s)
+    return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent 讓 API 支援 Idempotency-Key：第一次請求的回應會保存，相同的 key 重試時直接回放；
+// 第一次請求仍在處理中時回傳 409，相同的 key 搭配不同的內容時回傳 422。沒有帶 key 的請求照常處理
+func idempotent(scope string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        key := c.GetHeader("Idempotency-Key")
+        if key == "" {
+            c.Next()
+            return
+        }
+        if len(key) > maxIdempotencyKeyLength {
+            c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key 過長"})
+            return
+        }
+
+        body, err := io.ReadAll(c.Request.Body)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "無法讀取"})
+            return
+        }
+        c.Request.Body = io.NopCloser(bytes.NewReader(body))
+        sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+        hash := hex.EncodeToString(sum[:])
+
+        claimed, err := claimIdempotencyKey(scope, key, hash)
+        if err != nil {
+            log.Printf("claimIdempotencyKey error: %v", err)
+            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法處理請求"})
+            return
+        }
+        if !claimed {
+            replayIdempotentResponse(c, scope, key, hash)
+            return
+        }
+
+        // 伺服器錯誤或 panic 時不保存，讓用戶端可以用相同的 key 重試；
+        // 但請求已送往外部服務時，結果不明也保存失敗的回應，不再重送
+        release := func() {
+            if _, err := db.Exec("DELETE FROM IdempotencyKeys WHERE Scope = ? AND IdemKey = ?", scope, key); err != nil {
+                log.Printf("release idempotency key error: %v", err)
+            }
+        }
+        defer func() {
+            if r := recover(); r != nil {
+                if !c.GetBool(idempotencyUpstreamSent) {
+                    release()
+                }
+                panic(r)
+            }
+        }()
+
+        writer := &recordingWriter{ResponseWriter: c.Writer}
+        c.Writer = writer
+        c.Next()
+
+        status := writer.Status()
+        if status >= http.StatusInternalServerError && !c.GetBool(idempotencyUpstreamSent) {
+            release()
+            return
+        }
+
+        // 保存失敗時重試，仍失敗就釋出 key，避免重試的請求一直收到處理中
+        for attempt := 1; attempt <= idempotencySaveAttempts; attempt++ {
+            _, err = db.Exec("UPDATE IdempotencyKeys SET ResponseStatus = ?, ContentType = ?, ResponseBody = ? WHERE Scope = ? AND IdemKey = ?",
+                status, writer.Header().Get("Content-Type"), writer.body.Bytes(), scope, key)
+            if err == nil {
+                return
+            }
+            log.Printf("save idempotent response error (attempt %d): %v", attempt, err)
+        }
+        release()
+    }
+}
+
+// claimIdempotencyKey 先刪除已過期的紀錄再嘗試寫入，回傳這次請求是否取得該 key
+func claimIdempotencyKey(scope, key, hash string) (bool, error) {
+    now := time.Now()
+    if _, err := db.Exec("DELETE FROM IdempotencyKeys WHERE Scope = ? AND IdemKey = ? AND ExpiresAt <= ?", scope, key, now); err != nil {
+        return false, err
+    }
+    res, err := db.Exec("INSERT IGNORE INTO IdempotencyKeys (Scope, IdemKey, RequestHash, ExpiresAt) VALUES (?, ?, ?, ?)",
+        scope, key, hash, now.Add(idempotencyTTL()))
+    if err != nil {
+        return false, err
+    }
+    affected, err := res.RowsAffected()
+    return affected == 1, err
+}
+
+// replayIdempotentResponse 回放已保存的回應
+func replayIdempotentResponse(c *gin.Context, scope, key, hash string) {
+    var record idempotencyRecord
+    err := db.QueryRow("SELECT RequestHash, ResponseStatus, ContentType, ResponseBody FROM IdempotencyKeys WHERE Scope = ? AND IdemKey = ?",
+        scope, key).Scan(&record.RequestHash, &record.ResponseStatus, &record.ContentType, &record.ResponseBody)
+    if err == sql.ErrNoRows {
+        // 第一次請求失敗後已釋出，請用戶端重試
+        c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "相同 Idempotency-Key 的請求處理失敗，請重試"})
+        return
+    }
+    if err != nil {
+        log.Printf("replayIdempotentResponse error: %v", err)
+        c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "無法處理請求"})
+        return
+    }
+
+    if record.RequestHash != hash {
+        c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key 已用於不同的請求內容"})
+        return
+    }
+    if !record.ResponseStatus.Valid {
+        c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "相同 Idempotency-Key 的請求仍在處理中"})
+        return
+    }
+
+    c.Header("Idempotent-Replayed", "true")
+    c.Data(int(record.ResponseStatus.Int64), record.ContentType, record.ResponseBody)
+    c.Abort()
+}
+
+// purgeIdempotencyKeys 排程工作：刪除已過期的 Idempotency-Key，回傳執行摘要
+func purgeIdempotencyKeys(ctx context.Context) (string, error) {
+    res, err := db.ExecContext(ctx, "DELETE FROM IdempotencyKeys WHERE ExpiresAt <= ?", time.Now())
+    if err != nil {
+        return "", err
+    }
+    purged, _ := res.RowsAffected()
+    return fmt.Sprintf("purged %d idempotency keys", purged), nil
+}
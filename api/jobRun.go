@@ -0,0 +1,224 @@
+// jobRun.go
+package api
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// JobAlerter 排程工作連續失敗時的通知
+type JobAlerter interface {
+    JobFailed(run JobRun, consecutiveFailures int) error
+}
+
+// logAlerter 沒有設定 webhook 時只寫入日誌
+type logAlerter struct{}
+
+func (logAlerter) JobFailed(run JobRun, consecutiveFailures int) error {
+    log.Printf("排程警告: %s 已連續失敗 %d 次，最後錯誤: %s", run.JobName, consecutiveFailures, run.Error)
+    return nil
+}
+
+// webhookAlerter 將失敗通知以 JSON POST 到 webhook
+type webhookAlerter struct {
+    url string
+}
+
+func (a webhookAlerter) JobFailed(run JobRun, consecutiveFailures int) error {
+    body, err := json.Marshal(gin.H{
+        "text":                 fmt.Sprintf("排程 %s 已連續失敗 %d 次: %s", run.JobName, consecutiveFailures, run.Error),
+        "run":                  run,
+        "consecutive_failures": consecutiveFailures,
+    })
+    if err != nil {
+        return err
+    }
+
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Post(a.url, "application/json", bytes.NewBuffer(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("webhook 回應 %d", resp.StatusCode)
+    }
+    return nil
+}
+
+var jobAlerter JobAlerter
+
+// SetJobAlerter 替換排程失敗通知方式
+func SetJobAlerter(a JobAlerter) {
+    jobAlerter = a
+}
+
+// currentJobAlerter 沒有指定時依環境變數 JOB_ALERT_WEBHOOK_URL 決定使用 webhook 或日誌
+func currentJobAlerter() JobAlerter {
+    if jobAlerter != nil {
+        return jobAlerter
+    }
+    if url := os.Getenv("JOB_ALERT_WEBHOOK_URL"); url != "" {
+        return webhookAlerter{url: url}
+    }
+    return logAlerter{}
+}
+
+// 預設連續失敗幾次發出通知
+const defaultAlertAfterFailures = 3
+
+// alertAfterFailures 從環境變數 JOB_ALERT_AFTER_FAILURES 讀取
+func alertAfterFailures() int {
+    n, err := strconv.Atoi(os.Getenv("JOB_ALERT_AFTER_FAILURES"))
+    if err != nil || n <= 0 {
+        return defaultAlertAfterFailures
+    }
+    return n
+}
+
+// recordJobRun 執行工作並寫入執行紀錄，連續失敗達門檻時發出通知
+func recordJobRun(ctx context.Context, def jobDefinition) {
+    run := JobRun{JobName: def.Name, Instance: instanceID, StartedAt: time.Now(), Status: "running"}
+    res, err := db.Exec("INSERT INTO JobRuns (JobName, Instance, StartedAt, Status) VALUES (?, ?, ?, ?)", run.JobName, run.Instance, run.StartedAt, run.Status)
+    if err != nil {
+        log.Printf("寫入排程紀錄失敗: %v", err)
+    } else {
+        id, _ := res.LastInsertId()
+        run.ID = int(id)
+    }
+
+    summary, runErr := def.Run(ctx)
+    finishedAt := time.Now()
+    run.FinishedAt = &finishedAt
+    run.Summary = summary
+    run.Status = "success"
+    if runErr != nil {
+        run.Status = "failed"
+        run.Error = runErr.Error()
+        log.Printf("排程 %s 執行失敗: %v", def.Name, runErr)
+    }
+
+    if run.ID > 0 {
+        _, err := db.Exec("UPDATE JobRuns SET FinishedAt = ?, Status = ?, Error = ?, Summary = ? WHERE ID = ?",
+            finishedAt, run.Status, run.Error, run.Summary, run.ID)
+        if err != nil {
+            log.Printf("更新排程紀錄失敗: %v", err)
+        }
+    }
+
+    if runErr == nil {
+        return
+    }
+    failures, err := countConsecutiveFailures(def.Name)
+    if err != nil {
+        log.Printf("countConsecutiveFailures error: %v", err)
+        return
+    }
+    // 每達到門檻的倍數通知一次，避免每分鐘的工作一直通知
+    if threshold := alertAfterFailures(); failures > 0 && failures%threshold == 0 {
+        if err := currentJobAlerter().JobFailed(run, failures); err != nil {
+            log.Printf("排程失敗通知發送失敗: %v", err)
+        }
+    }
+}
+
+// countConsecutiveFailures 計算工作最近一次成功之後的失敗次數
+func countConsecutiveFailures(jobName string) (int, error) {
+    var failures int
+    err := db.QueryRow(`SELECT COUNT(*) FROM JobRuns WHERE JobName = ? AND Status = 'failed'
+        AND ID > COALESCE((SELECT MAX(ID) FROM JobRuns WHERE JobName = ? AND Status = 'success'), 0)`,
+        jobName, jobName).Scan(&failures)
+    return failures, err
+}
+
+// FetchJobRuns 依條件取得最近的執行紀錄
+func FetchJobRuns(jobName, status string, limit int) ([]JobRun, error) {
+    query := "SELECT ID, JobName, Instance, StartedAt, FinishedAt, Status, COALESCE(Error, ''), Summary FROM JobRuns WHERE 1 = 1"
+    var args []interface{}
+    if jobName != "" {
+        query += " AND JobName = ?"
+        args = append(args, jobName)
+    }
+    if status != "" {
+        query += " AND Status = ?"
+        args = append(args, status)
+    }
+    query += " ORDER BY ID DESC LIMIT ?"
+    args = append(args, limit)
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    runs := []JobRun{}
+    for rows.Next() {
+        var run JobRun
+        var startedAtString string
+        var finishedAt sql.NullString
+        if err := rows.Scan(&run.ID, &run.JobName, &run.Instance, &startedAtString, &finishedAt, &run.Status, &run.Error, &run.Summary); err != nil {
+            return nil, err
+        }
+        if run.StartedAt, err = time.Parse("2006-01-02 15:04:05", startedAtString); err != nil {
+            return nil, err
+        }
+        if run.FinishedAt, err = parseNullTime(finishedAt); err != nil {
+            return nil, err
+        }
+        runs = append(runs, run)
+    }
+
+    return runs, rows.Err()
+}
+
+// GetSchedulerStatusHandler 排程工作的狀態、租約持有者及執行紀錄，可用 job、status、limit 篩選紀錄
+func GetSchedulerStatusHandler(c *gin.Context) {
+    limit := 50
+    if limitStr := c.Query("limit"); limitStr != "" {
+        n, err := strconv.Atoi(limitStr)
+        if err != nil || n <= 0 || n > 500 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "無效的筆數"})
+            return
+        }
+        limit = n
+    }
+
+    jobs, err := FetchScheduledJobs()
+    if err != nil {
+        log.Printf("FetchScheduledJobs error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取排程"})
+        return
+    }
+
+    runs, err := FetchJobRuns(c.Query("job"), c.Query("status"), limit)
+    if err != nil {
+        log.Printf("FetchJobRuns error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取排程紀錄"})
+        return
+    }
+
+    lease, err := FetchSchedulerLease()
+    if err != nil {
+        log.Printf("FetchSchedulerLease error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取排程主機"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "schedulerActive": scheduler.IsJobActive(autoCreateJobName),
+        "lease":           lease,
+        "jobs":            jobs,
+        "runs":            runs,
+    })
+}
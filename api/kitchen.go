@@ -0,0 +1,300 @@
+// kitchen.go
+package api
+
+import (
+    "encoding/csv"
+    "fmt"
+    "html/template"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// ProductionItem 某時段需要製作的主餐或附餐選項份數，主餐的 Option 為空
+type ProductionItem struct {
+    ProductID int     `json:"product_id"`
+    Name      string  `json:"name"`
+    Option    string  `json:"option,omitempty"`
+    Quantity  float64 `json:"quantity"`
+}
+
+// ProductionSlot 某據點日期時段的備餐總量
+type ProductionSlot struct {
+    LocationID int              `json:"location_id"`
+    Date       string           `json:"date"`
+    TimeSlot   string           `json:"time_slot"`
+    Orders     int              `json:"orders"`
+    MainMeals  []ProductionItem `json:"main_meals"`
+    SideMeals  []ProductionItem `json:"side_meals"`
+}
+
+// productionFilter 備餐報表的條件，LocationID 為 0 表示所有據點，TimeSlot 為空表示所有時段
+type productionFilter struct {
+    LocationID int
+    StartDate  string
+    EndDate    string
+    TimeSlot   string
+}
+
+// where 組合 orders 的條件，已取消或退款的訂單不列入
+func (f productionFilter) where() (string, []interface{}) {
+    where := " WHERE o.delivery_date BETWEEN ? AND ? AND o.status_code NOT IN (" + releasedStatuses + ")"
+    args := []interface{}{f.StartDate, f.EndDate}
+    if f.LocationID != 0 {
+        where += " AND o.location_id = ?"
+        args = append(args, f.LocationID)
+    }
+    if f.TimeSlot != "" {
+        where += " AND o.delivery_time_range = ?"
+        args = append(args, f.TimeSlot)
+    }
+    return where, args
+}
+
+// parseProductionFilter 讀取 date (或 start_date、end_date)、location_id 及 time_slot，沒有指定日期時為今天
+func parseProductionFilter(c *gin.Context) (productionFilter, error) {
+    var filter productionFilter
+    if c.Query("location_id") != "" {
+        locationID, err := parseLocationID(c)
+        if err != nil {
+            return filter, err
+        }
+        filter.LocationID = locationID
+    }
+
+    startDate, endDate := c.Query("start_date"), c.Query("end_date")
+    if date := c.Query("date"); date != "" {
+        startDate, endDate = date, date
+    }
+    if startDate == "" {
+        startDate = time.Now().In(businessLocation()).Format("2006-01-02")
+    }
+    if endDate == "" {
+        endDate = startDate
+    }
+    if _, _, err := parseDateRange(startDate, endDate); err != nil {
+        return filter, err
+    }
+    filter.StartDate, filter.EndDate = startDate, endDate
+    filter.TimeSlot = c.Query("time_slot")
+    return filter, nil
+}
+
+// FetchProductionReport 依據點、日期、時段彙總主餐及附餐選項的份數，附餐份數為選項數量乘上主餐份數
+func FetchProductionReport(filter productionFilter) ([]ProductionSlot, error) {
+    where, args := filter.where()
+    var slots []ProductionSlot
+    index := make(map[string]int)
+    slotFor := func(locationID int, date, timeSlot string) *ProductionSlot {
+        key := fmt.Sprintf("%d|%s|%s", locationID, date, timeSlot)
+        i, ok := index[key]
+        if !ok {
+            i = len(slots)
+            index[key] = i
+            slots = append(slots, ProductionSlot{LocationID: locationID, Date: date, TimeSlot: timeSlot, MainMeals: []ProductionItem{}, SideMeals: []ProductionItem{}})
+        }
+        return &slots[i]
+    }
+
+    rows, err := db.Query(`SELECT o.location_id, DATE_FORMAT(o.delivery_date, '%Y-%m-%d'), o.delivery_time_range, COUNT(*)
+        FROM orders o`+where+`
+        GROUP BY o.location_id, o.delivery_date, o.delivery_time_range
+        ORDER BY o.delivery_date, o.delivery_time_range, o.location_id`, args...)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var locationID, orders int
+        var date, timeSlot string
+        if err := rows.Scan(&locationID, &date, &timeSlot, &orders); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        slotFor(locationID, date, timeSlot).Orders = orders
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    rows, err = db.Query(`SELECT o.location_id, DATE_FORMAT(o.delivery_date, '%Y-%m-%d'), o.delivery_time_range, p.product_id, p.name, SUM(p.quantity)
+        FROM orders o JOIN order_products p ON p.order_id = o.id`+where+`
+        GROUP BY o.location_id, o.delivery_date, o.delivery_time_range, p.product_id, p.name
+        ORDER BY p.product_id, p.name`, args...)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var locationID int
+        var date, timeSlot string
+        var item ProductionItem
+        if err := rows.Scan(&locationID, &date, &timeSlot, &item.ProductID, &item.Name, &item.Quantity); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        slot := slotFor(locationID, date, timeSlot)
+        slot.MainMeals = append(slot.MainMeals, item)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    rows, err = db.Query(`SELECT o.location_id, DATE_FORMAT(o.delivery_date, '%Y-%m-%d'), o.delivery_time_range, op.product_id, op.name, op.value, SUM(op.quantity * p.quantity)
+        FROM orders o JOIN order_products p ON p.order_id = o.id JOIN order_product_options op ON op.order_product_id = p.id`+where+`
+        GROUP BY o.location_id, o.delivery_date, o.delivery_time_range, op.product_id, op.name, op.value
+        ORDER BY op.name, op.value`, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var locationID int
+        var date, timeSlot string
+        var item ProductionItem
+        if err := rows.Scan(&locationID, &date, &timeSlot, &item.ProductID, &item.Name, &item.Option, &item.Quantity); err != nil {
+            return nil, err
+        }
+        slot := slotFor(locationID, date, timeSlot)
+        slot.SideMeals = append(slot.SideMeals, item)
+    }
+
+    return slots, rows.Err()
+}
+
+// productionReport 讀取條件並取得報表，失敗時已回應錯誤
+func productionReport(c *gin.Context) (productionFilter, []ProductionSlot, bool) {
+    filter, err := parseProductionFilter(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return filter, nil, false
+    }
+    slots, err := FetchProductionReport(filter)
+    if err != nil {
+        log.Printf("FetchProductionReport error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生備餐報表"})
+        return filter, nil, false
+    }
+    return filter, slots, true
+}
+
+// GetProductionReport 取得各據點日期時段需要製作的主餐及附餐總量
+func GetProductionReport(c *gin.Context) {
+    _, slots, ok := productionReport(c)
+    if !ok {
+        return
+    }
+    if slots == nil {
+        slots = []ProductionSlot{}
+    }
+
+    c.JSON(http.StatusOK, slots)
+}
+
+// ExportProductionCSV 以 CSV 匯出備餐報表，每列一個主餐或附餐選項
+func ExportProductionCSV(c *gin.Context) {
+    filter, slots, ok := productionReport(c)
+    if !ok {
+        return
+    }
+
+    c.Header("Content-Type", "text/csv; charset=utf-8")
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=production-%s.csv", filter.StartDate))
+    c.Status(http.StatusOK)
+
+    // 加上 BOM 讓 Excel 正確辨識 UTF-8
+    c.Writer.WriteString("\xEF\xBB\xBF")
+    w := csv.NewWriter(c.Writer)
+    w.Write([]string{"location_id", "date", "time_slot", "type", "product_id", "name", "option", "quantity"})
+    for _, slot := range slots {
+        for _, item := range slot.MainMeals {
+            w.Write(productionCSVRow(slot, "main", item))
+        }
+        for _, item := range slot.SideMeals {
+            w.Write(productionCSVRow(slot, "side", item))
+        }
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        log.Printf("ExportProductionCSV error: %v", err)
+    }
+}
+
+func productionCSVRow(slot ProductionSlot, kind string, item ProductionItem) []string {
+    return []string{
+        strconv.Itoa(slot.LocationID),
+        slot.Date,
+        slot.TimeSlot,
+        kind,
+        strconv.Itoa(item.ProductID),
+        item.Name,
+        item.Option,
+        strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+    }
+}
+
+// productionSheet 列印用的備餐單，每個時段一頁，可用瀏覽器列印或另存 PDF
+var productionSheet = template.Must(template.New("production").Funcs(template.FuncMap{
+    "qty": func(q float64) string { return strconv.FormatFloat(q, 'f', -1, 64) },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-Hant">
+<head>
+<meta charset="utf-8">
+<title>備餐單 {{.StartDate}}{{if ne .StartDate .EndDate}} ~ {{.EndDate}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; }
+section { page-break-after: always; }
+section:last-child { page-break-after: auto; }
+h2 { margin-bottom: 4px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 16px; }
+th, td { border: 1px solid #333; padding: 6px 8px; text-align: left; }
+td.qty { text-align: right; width: 80px; font-weight: bold; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+{{range .Slots}}
+<section>
+<h2>{{.Date}} {{.TimeSlot}}</h2>
+<p>據點 {{.LocationID}}，共 {{.Orders}} 筆訂單</p>
+<table>
+<tr><th>主餐</th><th>份數</th></tr>
+{{range .MainMeals}}<tr><td>{{.Name}}</td><td class="qty">{{qty .Quantity}}</td></tr>
+{{end}}
+</table>
+{{if .SideMeals}}
+<table>
+<tr><th>附餐</th><th>選項</th><th>份數</th></tr>
+{{range .SideMeals}}<tr><td>{{.Name}}</td><td>{{.Option}}</td><td class="qty">{{qty .Quantity}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</section>
+{{else}}
+<p>沒有需要製作的訂單</p>
+{{end}}
+</body>
+</html>
+`))
+
+// ExportProductionHTML 輸出可列印的備餐單
+func ExportProductionHTML(c *gin.Context) {
+    filter, slots, ok := productionReport(c)
+    if !ok {
+        return
+    }
+
+    c.Header("Content-Type", "text/html; charset=utf-8")
+    c.Status(http.StatusOK)
+    err := productionSheet.Execute(c.Writer, gin.H{
+        "StartDate": filter.StartDate,
+        "EndDate":   filter.EndDate,
+        "Slots":     slots,
+    })
+    if err != nil {
+        log.Printf("ExportProductionHTML error: %v", err)
+    }
+}
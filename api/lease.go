@@ -0,0 +1,75 @@
+// lease.go
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+)
+
+// 多台伺服器共用資料庫時，只有持有排程租約的伺服器會執行排程工作
+const (
+    schedulerLeaseName = "scheduler"
+    leaseTTL           = 30 * time.Second
+    leaseRenewInterval = 10 * time.Second
+)
+
+var errNotLeader = errors.New("此伺服器不是排程主機")
+
+// instanceID 用來識別這台伺服器，可用環境變數 INSTANCE_ID 指定
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+    if id := os.Getenv("INSTANCE_ID"); id != "" {
+        return id
+    }
+    host, _ := os.Hostname()
+    return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// SchedulerLease 表示目前的租約持有者
+type SchedulerLease struct {
+    Holder    string `json:"holder"`
+    ExpiresAt string `json:"expires_at"`
+    Instance  string `json:"instance"`
+    IsLeader  bool   `json:"is_leader"`
+}
+
+// acquireLease 取得或續約租約，租約由其他伺服器持有且未過期時回傳 false。
+// 以資料庫時間判斷過期，避免各伺服器時鐘不同步；持有者停止續約超過 leaseTTL 後由其他伺服器接手。
+func acquireLease(name string) (bool, error) {
+    _, err := db.Exec(`INSERT INTO SchedulerLeases (Name, Holder, ExpiresAt) VALUES (?, ?, NOW() + INTERVAL ? SECOND)
+        ON DUPLICATE KEY UPDATE
+            Holder = IF(Holder = VALUES(Holder) OR ExpiresAt < NOW(), VALUES(Holder), Holder),
+            ExpiresAt = IF(Holder = VALUES(Holder), VALUES(ExpiresAt), ExpiresAt)`,
+        name, instanceID, int(leaseTTL.Seconds()))
+    if err != nil {
+        return false, err
+    }
+
+    var holder string
+    if err := db.QueryRow("SELECT Holder FROM SchedulerLeases WHERE Name = ?", name).Scan(&holder); err != nil {
+        return false, err
+    }
+    return holder == instanceID, nil
+}
+
+// releaseLease 關閉時釋放自己持有的租約，讓其他伺服器立即接手
+func releaseLease(name string) error {
+    _, err := db.Exec("DELETE FROM SchedulerLeases WHERE Name = ? AND Holder = ?", name, instanceID)
+    return err
+}
+
+// FetchSchedulerLease 取得目前的排程租約，沒有人持有或已過期時 Holder 為空
+func FetchSchedulerLease() (*SchedulerLease, error) {
+    lease := SchedulerLease{Instance: instanceID}
+    err := db.QueryRow("SELECT Holder, ExpiresAt FROM SchedulerLeases WHERE Name = ? AND ExpiresAt >= NOW()", schedulerLeaseName).Scan(
+        &lease.Holder, &lease.ExpiresAt)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, err
+    }
+    lease.IsLeader = lease.Holder == instanceID
+    return &lease, nil
+}
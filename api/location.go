@@ -0,0 +1,46 @@
+// location.go
+package api
+
+import (
+    "errors"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+)
+
+// defaultLocationID 未指定廚房時使用的預設據點
+const defaultLocationID = 1
+
+// parseLocationID 從查詢參數 location_id 取得據點，沒有提供時回傳預設據點
+func parseLocationID(c *gin.Context) (int, error) {
+    locationStr := c.Query("location_id")
+    if locationStr == "" {
+        return defaultLocationID, nil
+    }
+
+    locationID, err := strconv.Atoi(locationStr)
+    if err != nil || locationID <= 0 {
+        return 0, errors.New("無效的據點 ID")
+    }
+    return locationID, nil
+}
+
+// FetchLocationIDs 取得所有有預設時段模板的據點
+func FetchLocationIDs() ([]int, error) {
+    var locationIDs []int
+    rows, err := db.Query("SELECT DISTINCT LocationID FROM TimeSlotLimits ORDER BY LocationID")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var locationID int
+        if err := rows.Scan(&locationID); err != nil {
+            return nil, err
+        }
+        locationIDs = append(locationIDs, locationID)
+    }
+
+    return locationIDs, nil
+}
@@ -0,0 +1,297 @@
+// manifest.go
+package api
+
+import (
+    "encoding/csv"
+    "fmt"
+    "html/template"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// ManifestStop 派送單上的一筆訂單，外送員取最後一次指派，沒有指派時 Driver 為空
+type ManifestStop struct {
+    OrderID     int    `json:"order_id"`
+    Code        string `json:"code"`
+    TimeSlot    string `json:"time_slot"`
+    Name        string `json:"personal_name"`
+    Mobile      string `json:"mobile"`
+    Address     string `json:"address"`
+    StatusCode  string `json:"status_code"`
+    Total       int    `json:"total"`
+    Meals       string `json:"meals"`
+    Driver      string `json:"driver"`
+    DriverPhone string `json:"driver_phone"`
+    CarType     string `json:"cartype"`
+}
+
+// ManifestRoad 同一條路的派送訂單，依地址排序
+type ManifestRoad struct {
+    Road  string         `json:"road"`
+    Stops []ManifestStop `json:"stops"`
+}
+
+// ManifestCity 同一個城市的派送訂單，依路名分組
+type ManifestCity struct {
+    CityID int            `json:"city_id"`
+    Roads  []ManifestRoad `json:"roads"`
+}
+
+// DeliveryManifest 某據點日期時段的派送單
+type DeliveryManifest struct {
+    LocationID int            `json:"location_id"`
+    Date       string         `json:"date"`
+    TimeSlot   string         `json:"time_slot"`
+    Orders     int            `json:"orders"`
+    Cities     []ManifestCity `json:"cities"`
+}
+
+// FetchDeliveryManifest 取得據點日期的派送訂單 (已取消或退款的不列入)，依城市、路名、地址排序分組，
+// timeSlot 為空時包含所有時段
+func FetchDeliveryManifest(locationID int, date, timeSlot string) (*DeliveryManifest, error) {
+    query := `SELECT o.id, o.code, o.delivery_time_range, o.personal_name, o.mobile, o.shipping_city_id, o.shipping_road, o.shipping_address1,
+            o.status_code, o.total, COALESCE(d.name, ''), COALESCE(d.phone, ''), COALESCE(d.cartype, '')
+        FROM orders o LEFT JOIN order_delivery d ON d.id = (SELECT MAX(id) FROM order_delivery WHERE order_code = o.code)
+        WHERE o.location_id = ? AND o.delivery_date = ? AND o.status_code NOT IN (` + releasedStatuses + `)`
+    args := []interface{}{locationID, date}
+    if timeSlot != "" {
+        query += " AND o.delivery_time_range = ?"
+        args = append(args, timeSlot)
+    }
+    query += " ORDER BY o.shipping_city_id, o.shipping_road, o.shipping_address1, o.delivery_time_range, o.id"
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    manifest := &DeliveryManifest{LocationID: locationID, Date: date, TimeSlot: timeSlot, Cities: []ManifestCity{}}
+    var orderIDs []interface{}
+    for rows.Next() {
+        var stop ManifestStop
+        var cityID int
+        var road, address string
+        if err := rows.Scan(&stop.OrderID, &stop.Code, &stop.TimeSlot, &stop.Name, &stop.Mobile, &cityID, &road, &address,
+            &stop.StatusCode, &stop.Total, &stop.Driver, &stop.DriverPhone, &stop.CarType); err != nil {
+            return nil, err
+        }
+        stop.Address = road + address
+
+        if n := len(manifest.Cities); n == 0 || manifest.Cities[n-1].CityID != cityID {
+            manifest.Cities = append(manifest.Cities, ManifestCity{CityID: cityID})
+        }
+        city := &manifest.Cities[len(manifest.Cities)-1]
+        if n := len(city.Roads); n == 0 || city.Roads[n-1].Road != road {
+            city.Roads = append(city.Roads, ManifestRoad{Road: road})
+        }
+        roadGroup := &city.Roads[len(city.Roads)-1]
+        roadGroup.Stops = append(roadGroup.Stops, stop)
+
+        orderIDs = append(orderIDs, stop.OrderID)
+        manifest.Orders++
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    if len(orderIDs) == 0 {
+        return manifest, nil
+    }
+
+    meals, err := fetchMealSummaries(orderIDs)
+    if err != nil {
+        return nil, err
+    }
+    for i := range manifest.Cities {
+        for j := range manifest.Cities[i].Roads {
+            stops := manifest.Cities[i].Roads[j].Stops
+            for k := range stops {
+                stops[k].Meals = meals[stops[k].OrderID]
+            }
+        }
+    }
+    return manifest, nil
+}
+
+// fetchMealSummaries 一次取得多筆訂單的餐點摘要，例如「雞腿便當 x2 (紅茶、加蛋)」，以訂單 ID 為 key
+func fetchMealSummaries(orderIDs []interface{}) (map[int]string, error) {
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(orderIDs)), ", ")
+    rows, err := db.Query(`SELECT p.order_id, p.id, p.name, p.quantity, COALESCE(o.value, '')
+        FROM order_products p LEFT JOIN order_product_options o ON o.order_product_id = p.id
+        WHERE p.order_id IN (`+placeholders+`) ORDER BY p.order_id, p.sort_order, p.id, o.id`, orderIDs...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    type mealLine struct {
+        id      int
+        name    string
+        options []string
+    }
+    lines := make(map[int][]mealLine)
+    for rows.Next() {
+        var orderID, mainID, quantity int
+        var name, option string
+        if err := rows.Scan(&orderID, &mainID, &name, &quantity, &option); err != nil {
+            return nil, err
+        }
+        meals := lines[orderID]
+        if n := len(meals); n == 0 || meals[n-1].id != mainID {
+            meals = append(meals, mealLine{id: mainID, name: fmt.Sprintf("%s x%d", name, quantity)})
+        }
+        if option != "" {
+            meals[len(meals)-1].options = append(meals[len(meals)-1].options, option)
+        }
+        lines[orderID] = meals
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    summaries := make(map[int]string, len(lines))
+    for orderID, meals := range lines {
+        parts := make([]string, 0, len(meals))
+        for _, meal := range meals {
+            if len(meal.options) > 0 {
+                parts = append(parts, meal.name+" ("+strings.Join(meal.options, "、")+")")
+            } else {
+                parts = append(parts, meal.name)
+            }
+        }
+        summaries[orderID] = strings.Join(parts, "；")
+    }
+    return summaries, nil
+}
+
+// deliveryManifest 讀取 location_id、date 及 time_slot 並取得派送單，失敗時已回應錯誤
+func deliveryManifest(c *gin.Context) (*DeliveryManifest, bool) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return nil, false
+    }
+    date := c.Query("date")
+    if _, _, err := parseDateRange(date, date); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供日期 (YYYY-MM-DD)"})
+        return nil, false
+    }
+
+    manifest, err := FetchDeliveryManifest(locationID, date, c.Query("time_slot"))
+    if err != nil {
+        log.Printf("FetchDeliveryManifest error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法產生派送單"})
+        return nil, false
+    }
+    return manifest, true
+}
+
+// GetDeliveryManifest 取得某日期時段依城市及路名分組的派送訂單
+func GetDeliveryManifest(c *gin.Context) {
+    manifest, ok := deliveryManifest(c)
+    if !ok {
+        return
+    }
+
+    c.JSON(http.StatusOK, manifest)
+}
+
+// ExportDeliveryManifestCSV 以 CSV 匯出派送單，每列一筆訂單
+func ExportDeliveryManifestCSV(c *gin.Context) {
+    manifest, ok := deliveryManifest(c)
+    if !ok {
+        return
+    }
+
+    c.Header("Content-Type", "text/csv; charset=utf-8")
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=manifest-%d-%s.csv", manifest.LocationID, manifest.Date))
+    c.Status(http.StatusOK)
+
+    // 加上 BOM 讓 Excel 正確辨識 UTF-8
+    c.Writer.WriteString("\xEF\xBB\xBF")
+    w := csv.NewWriter(c.Writer)
+    w.Write([]string{"date", "time_slot", "city_id", "road", "code", "personal_name", "mobile", "address", "meals", "total", "status", "driver", "driver_phone", "cartype"})
+    for _, city := range manifest.Cities {
+        for _, road := range city.Roads {
+            for _, stop := range road.Stops {
+                w.Write([]string{
+                    manifest.Date,
+                    stop.TimeSlot,
+                    strconv.Itoa(city.CityID),
+                    road.Road,
+                    stop.Code,
+                    stop.Name,
+                    stop.Mobile,
+                    stop.Address,
+                    stop.Meals,
+                    strconv.Itoa(stop.Total),
+                    stop.StatusCode,
+                    stop.Driver,
+                    stop.DriverPhone,
+                    stop.CarType,
+                })
+            }
+        }
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        log.Printf("ExportDeliveryManifestCSV error: %v", err)
+    }
+}
+
+// manifestSheet 列印用的派送單，每個城市換頁，可用瀏覽器列印或另存 PDF
+var manifestSheet = template.Must(template.New("manifest").Parse(`<!DOCTYPE html>
+<html lang="zh-Hant">
+<head>
+<meta charset="utf-8">
+<title>派送單 {{.Date}} {{.TimeSlot}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; }
+section { page-break-after: always; }
+section:last-child { page-break-after: auto; }
+h3 { margin: 12px 0 4px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 12px; font-size: 13px; }
+th, td { border: 1px solid #333; padding: 4px 6px; text-align: left; vertical-align: top; }
+td.check { width: 24px; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>派送單 {{.Date}} {{if .TimeSlot}}{{.TimeSlot}}{{else}}全部時段{{end}}</h1>
+<p>據點 {{.LocationID}}，共 {{.Orders}} 筆訂單</p>
+{{range .Cities}}
+<section>
+<h2>城市 {{.CityID}}</h2>
+{{range .Roads}}
+<h3>{{.Road}}</h3>
+<table>
+<tr><th></th><th>時段</th><th>訂單編號</th><th>姓名</th><th>電話</th><th>地址</th><th>餐點</th><th>金額</th><th>外送員</th></tr>
+{{range .Stops}}<tr><td class="check">☐</td><td>{{.TimeSlot}}</td><td>{{.Code}}</td><td>{{.Name}}</td><td>{{.Mobile}}</td><td>{{.Address}}</td><td>{{.Meals}}</td><td>{{.Total}}</td><td>{{.Driver}}{{if .DriverPhone}} {{.DriverPhone}}{{end}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</section>
+{{else}}
+<p>沒有需要派送的訂單</p>
+{{end}}
+</body>
+</html>
+`))
+
+// ExportDeliveryManifestHTML 輸出可列印的派送單
+func ExportDeliveryManifestHTML(c *gin.Context) {
+    manifest, ok := deliveryManifest(c)
+    if !ok {
+        return
+    }
+
+    c.Header("Content-Type", "text/html; charset=utf-8")
+    c.Status(http.StatusOK)
+    if err := manifestSheet.Execute(c.Writer, manifest); err != nil {
+        log.Printf("ExportDeliveryManifestHTML error: %v", err)
+    }
+}
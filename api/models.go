@@ -5,6 +5,7 @@ import "time"
 
 // TimeSlotLimit  表示時段限制的結構
 type TimeSlotLimit struct {
+    LocationID int    `json:"location_id"`
     TimeSlot   string `json:"time_slot"`
     LimitCount int    `json:"limit_count"`
 }
@@ -13,6 +14,7 @@ type TimeSlotLimits []TimeSlotLimit
 
 // SpecificDateLimit  表示特定日期的時段限制結構
 type SpecificDateLimit struct {
+    LocationID int                     `json:"location_id"`
     Date       string                  `json:"date"`
     TimeLimits map[string]int          `json:"time_limits"`
     
@@ -20,6 +22,139 @@ type SpecificDateLimit struct {
 
 
 
+// BulkLimitRequest 批次修改日期範圍內的時段上限
+type BulkLimitRequest struct {
+    StartDate string   `json:"start_date"`
+    EndDate   string   `json:"end_date"`
+    Weekdays  []int    `json:"weekdays"`   // 0 為星期日，空白表示每天
+    TimeSlots []string `json:"time_slots"` // 空白表示所有時段
+    Action    string   `json:"action"`     // set、add、scale、close、forecast
+    Value     int      `json:"value"`      // set 為數量，add 為增減量，scale 為百分比，forecast 為參考週數
+    Upsert    bool     `json:"upsert"`     // 沒有設定的時段是否新增
+    DryRun    bool     `json:"dry_run"`
+}
+
+// LimitChange 表示一個時段上限的變更
+type LimitChange struct {
+    Date     string `json:"date"`
+    TimeSlot string `json:"time_slot"`
+    Old      int    `json:"old"`
+    New      int    `json:"new"`
+    Created  bool   `json:"created"`
+    Reason   string `json:"reason,omitempty"` // 略過的原因：no_limit 為沒有設定，no_samples 為預測沒有歷史資料或歷史上沒有訂單
+}
+
+// CapacityHold 表示結帳期間暫時保留的名額
+type CapacityHold struct {
+    Token      string    `json:"token"`
+    LocationID int       `json:"location_id"`
+    Date       string    `json:"date"`
+    TimeSlot   string    `json:"time_slot"`
+    Quantity   int       `json:"quantity"`
+    ExpiresAt  time.Time `json:"expires_at"`
+    ClientIP   string    `json:"-"` // 建立保留的來源 IP，候補轉成的保留為空
+}
+
+// SlotAvailability 表示某時段的剩餘名額
+type SlotAvailability struct {
+    TimeSlot  string `json:"time_slot"`
+    Limit     int    `json:"limit"`
+    Booked    int    `json:"booked"`
+    Held      int    `json:"held"`
+    Available int    `json:"available"`
+    CutoffAt  string `json:"cutoff_at,omitempty"`
+}
+
+// SlotCutoff 表示時段的下單截止規則，例如前一天 20:00 或當天 10:00
+type SlotCutoff struct {
+    LocationID int    `json:"location_id"`
+    TimeSlot   string `json:"time_slot"`
+    DaysBefore int    `json:"days_before"` // 0 為當天，1 為前一天
+    CutoffTime string `json:"cutoff_time"` // HH:MM
+}
+
+// WaitlistEntry 表示額滿時段的候補
+type WaitlistEntry struct {
+    ID          int    `json:"id"`
+    LocationID  int    `json:"location_id"`
+    Date        string `json:"date"`
+    TimeSlot    string `json:"time_slot"`
+    Name        string `json:"name"`
+    Mobile      string `json:"mobile"`
+    Email       string `json:"email"`
+    Quantity    int    `json:"quantity"`
+    Status      string `json:"status"` // waiting、offered、accepted、expired、cancelled
+    HoldToken   string `json:"hold_token,omitempty"`
+    // CancelToken 只在登記時回傳一次，客人取消候補時使用
+    CancelToken string `json:"cancel_token,omitempty"`
+}
+
+// ScheduledJob 表示資料庫中的排程工作設定
+type ScheduledJob struct {
+    Name      string     `json:"name"`
+    Cron      string     `json:"cron"`
+    Status    string     `json:"status"` // active、paused、stopped
+    NextRunAt *time.Time `json:"next_run_at"`
+    LastRunAt *time.Time `json:"last_run_at"`
+}
+
+// JobRun 表示排程工作的一次執行紀錄
+type JobRun struct {
+    ID         int        `json:"id"`
+    JobName    string     `json:"job_name"`
+    Instance   string     `json:"instance"` // 執行的伺服器
+    StartedAt  time.Time  `json:"started_at"`
+    FinishedAt *time.Time `json:"finished_at"`
+    Status     string     `json:"status"` // running、success、failed
+    Error      string     `json:"error"`
+    Summary    string     `json:"summary"`
+}
+
+// CapacityAudit 表示一筆上限或預設模板的變更紀錄，只新增不修改
+type CapacityAudit struct {
+    ID         int    `json:"id"`
+    LocationID int    `json:"location_id"`
+    Scope      string `json:"scope"` // template、date、zone
+    Date       string `json:"date,omitempty"`
+    TimeSlot   string `json:"time_slot"`
+    ZoneID     int    `json:"zone_id,omitempty"`
+    OldValue   *int   `json:"old_value"` // nil 表示新增
+    NewValue   *int   `json:"new_value"` // nil 表示刪除
+    Actor      string `json:"actor"`
+    Source     string `json:"source"` // api、scheduler、bulk
+    CreatedAt  string `json:"created_at"`
+}
+
+// DateLimits 的來源：template 為手動觸發自動新增、auto 為排程新增、manual 為人工修改
+const (
+    originTemplate = "template"
+    originAuto     = "auto"
+    originManual   = "manual"
+)
+
+// AutoCreateOptions 自動新增的參數
+type AutoCreateOptions struct {
+    Period    string // oneWeek、twoWeeks、oneMonth、twoMonths
+    StartDate string // 指定開始日期 (含)，與 EndDate 一起使用時取代 Period
+    EndDate   string // 指定結束日期 (含)
+    Cover     bool   // 覆蓋已有設定的日期
+    Force     bool   // 覆蓋時連人工修改過的時段也覆蓋
+    DryRun    bool   // 只回傳會寫入的變更，不寫入
+    Forecast  bool   // 以歷史訂單預測的建議上限取代預設模板的數量
+    Origin    string // 寫入的來源
+    Actor     Actor
+}
+
+// AutoCreateReport 自動新增的結果
+type AutoCreateReport struct {
+    StartDate   string        `json:"start_date"`
+    EndDate     string        `json:"end_date"`
+    DryRun      bool          `json:"dry_run"`
+    Created     []LimitChange `json:"created"`
+    Overwritten []LimitChange `json:"overwritten"`
+    Skipped     []LimitChange `json:"skipped"` // 人工修改過而未覆蓋的時段
+}
+
 // Road 表示路名和城市 ID 的結構
 type Road struct {
     Name    string `json:"name"`
@@ -29,11 +164,37 @@ type Road struct {
 // Roads 是多個 Road 的切片
 type Roads []Road
 
+// DeliveryZone 表示外送區域，由多個城市或路段組成
+type DeliveryZone struct {
+    ID         int        `json:"id"`
+    LocationID int        `json:"location_id"`
+    Name       string     `json:"name"`
+    DeliveryFee      int  `json:"delivery_fee"`
+    FreeDeliveryOver int  `json:"free_delivery_over"` // 達到此金額免運，0 表示不免運
+    Areas      []ZoneArea `json:"areas"`
+}
+
+// ZoneArea 表示區域涵蓋的城市，Road 為空代表整個城市
+type ZoneArea struct {
+    CityID int    `json:"city_id"`
+    Road   string `json:"road"`
+}
+
+// ZoneLimit 表示特定日期時段在某區域的外送上限
+type ZoneLimit struct {
+    Date       string `json:"date"`
+    TimeSlot   string `json:"time_slot"`
+    ZoneID     int    `json:"zone_id"`
+    LimitCount int    `json:"limit_count"`
+    Booked     int    `json:"booked"`
+}
+
 // Order 表示訂單的結構
 type Order struct {
     OrderID           int    `json:"orderID"`
 	ID                int    `json:"id"`
     Code              string `json:"code"`
+    LocationID        int    `json:"location_id"`
     PersonalName      string `json:"personal_name"`
     DeliveryDate      string `json:"delivery_date"` // 新增的字段，只包含日期
     ShippingStateID   int    `json:"shipping_state_id"`
@@ -45,12 +206,16 @@ type Order struct {
     DeliveryTimeRange string `json:"delivery_time_range"`
     Mobile            string `json:"mobile"`
     ShippingStatus   int  `json:"shipping_status"`
+    Subtotal          int    `json:"subtotal"`
+    Discount          int    `json:"discount"`
+    DeliveryFee       int    `json:"delivery_fee"`
+    Total             int    `json:"total"`
 
 }
 // 外送員
 type orderDelivery struct{
     ID                int    `json:"id"`
-    OrderCode       int    `json:"order_code"`
+    OrderCode       string `json:"order_code"`
     DeliveryID       int    `json:"delivery_id"`
     Name       string    `json:"name"`
     Phone       string    `json:"phone"`
@@ -76,6 +241,8 @@ type OrderProduct struct {
     ProductID    int    `json:"product_id"`
     Name         string `json:"name"`
     Quantity     int    `json:"quantity"`
+    UnitPrice    int    `json:"unit_price"` // 下單當時的單價，由商品目錄帶入
+    Delete       bool   `json:"delete,omitempty"` // 修改訂單餐點時標記刪除
 }
 
 // OrderProductOption 表示 order_product_options 表的結構
@@ -86,7 +253,43 @@ type OrderProductOption struct {
     Name            string `json:"name"`
     Value           string `json:"value"`
     Quantity        float64    `json:"quantity"`
+    UnitPrice       int    `json:"unit_price"` // 下單當時的加價，由商品目錄帶入
+    Delete          bool   `json:"delete,omitempty"` // 修改訂單餐點時標記刪除
+}
+// Product 商品目錄中的主餐
+type Product struct {
+    ID           int           `json:"id"`
+    Name         string        `json:"name"`
+    Description  string        `json:"description"`
+    Price        int           `json:"price"`
+    ImageID      *int          `json:"image_id"`
+    Available    bool          `json:"available"`
+    SortOrder    int           `json:"sort_order"`
+    OptionGroups []OptionGroup `json:"option_groups"`
+}
+
+// OptionGroup 選項群組，例如附餐、飲料，可以套用在多個主餐上
+type OptionGroup struct {
+    ID         int             `json:"id"`
+    Name       string          `json:"name"`
+    MinSelect  int             `json:"min_select"`
+    MaxSelect  int             `json:"max_select"` // 0 表示不限
+    SortOrder  int             `json:"sort_order"`
+    ProductIDs []int           `json:"product_ids,omitempty"`
+    Options    []ProductOption `json:"options"`
 }
+
+// ProductOption 選項群組中的選項，Price 為加價
+type ProductOption struct {
+    ID        int    `json:"id"`
+    GroupID   int    `json:"group_id"`
+    Name      string `json:"name"`
+    Price     int    `json:"price"`
+    ImageID   *int   `json:"image_id"`
+    Available bool   `json:"available"`
+    SortOrder int    `json:"sort_order"`
+}
+
 //餐點呈現
 type CompleteMeal struct {
     MainMeal  OrderProduct       `json:"main_meal"`
@@ -94,10 +297,11 @@ type CompleteMeal struct {
 }
 //新的餐點
 type NewOrderRequest struct {
-	Code                   int  `json:"code"`
+	Code                   string  `json:"code"` // 由系統產生，只有管理員匯入時可以指定
 	CustomerID       int   `json:"customer_id"`
 	LocationID         int  `json:"location_id"`
     PersonalName     string `json:"personal_name"`
+    Mobile           string `json:"mobile"` // 顧客取消或修改訂單時用來確認身分
     DeliveryDate     string `json:"delivery_date"`
     ShippingStateID  int    `json:"shipping_state_id"`
     ShippingCityID   int    `json:"shipping_city_id"`
@@ -106,6 +310,24 @@ type NewOrderRequest struct {
     StatusCode       string `json:"status_code"`
     DeliveryTimeRange string `json:"delivery_time_range"`
     OrderMeals       []OrderMeal `json:"order_meals"`
+    HoldToken        string `json:"hold_token"` // 結帳前保留名額取得的憑證
+    OverrideCutoff   bool   `json:"override_cutoff"` // 電話訂單由管理員略過截止時間
+    Import           bool   `json:"import"` // 管理員匯入舊訂單，沿用原本的編號
+    DiscountCode     string `json:"discount_code"`
+}
+
+// CreatedOrder 新增訂單後回傳的資料，餐點帶有產生的 ID
+type CreatedOrder struct {
+    ID                int64       `json:"id"`
+    Code              string      `json:"code"`
+    LocationID        int         `json:"location_id"`
+    DeliveryDate      string      `json:"delivery_date"`
+    DeliveryTimeRange string      `json:"delivery_time_range"`
+    ZoneID            int         `json:"zone_id"`
+    StatusCode        string      `json:"status_code"`
+    Mobile            string      `json:"mobile,omitempty"`
+    OrderMeals        []OrderMeal `json:"order_meals"`
+    Pricing           *OrderQuote `json:"pricing,omitempty"`
 }
 
 type OrderMeal struct {
@@ -115,7 +337,8 @@ type OrderMeal struct {
 
 // OrderData 用於接收從前端傳來的訂單數據
 type OrderData struct {
-    Amount    int      `json:"amount"`    // 訂單金額
+    OrderCode string   `json:"order_code"` // 要付款的訂單編號
+    Amount    int      `json:"amount"`    // 訂單金額，一律由伺服器依訂單總額帶入
     TradeDesc string   `json:"tradeDesc"` // 交易描述
     ItemNames []string `json:"itemNames"` // 商品名稱列表
     // 根據需要添加其他字段，如客戶信息等
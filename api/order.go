@@ -3,7 +3,8 @@ package api
 
 import (
 	"bytes"
-	"database/sql"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -48,37 +49,6 @@ func GetOrderByCriteria(c *gin.Context)  {
 
      c.JSON(http.StatusOK, orders)
 }
-// UpdateOrderStatusHandler 更改訂單狀態
-func UpdateOrderStatusHandler(c *gin.Context) {
-    orderCode := c.Param("code")
-    
-    // 訂單是否存在
-    var orderId int
-    err := db.QueryRow("SELECT id FROM orders WHERE code = ?", orderCode).Scan(&orderId)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            // 不存在
-            c.JSON(http.StatusNotFound, gin.H{"error": "沒有該筆訂單"})
-        } else {
-            // 資料庫錯誤
-            log.Printf("Query error: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "查詢訂單時發生錯誤"})
-        }
-        return
-    }
-
-    // 存在订单，更新其状态
-    err = UpdateOrderStatus(orderCode)
-    if err != nil {
-        log.Printf("Update error: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新訂單狀態"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"message": "訂單狀態已更新為作廢"})
-}
-
-
 // GetOrderProducts 根據訂單 ID 獲取訂單餐點
 func GetOrderProducts(c *gin.Context) {
     orderID, err := strconv.Atoi(c.Param("order_id"))
@@ -155,101 +125,216 @@ func CreateNewOrder(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
+    if newOrderReq.LocationID == 0 {
+        newOrderReq.LocationID = defaultLocationID
+    }
+    // 訂單編號由系統產生，管理員匯入舊訂單時才可以沿用原本的編號
+    if newOrderReq.Code != "" || newOrderReq.Import {
+        if !newOrderReq.Import || newOrderReq.Code == "" || !isAdminRequest(c) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": errClientOrderCode.Error()})
+            return
+        }
+    }
+
+    if len(newOrderReq.OrderMeals) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請至少選擇一項餐點"})
+        return
+    }
 
-    // 插入訂單基本資料並獲取 order_id
-    res, err := db.Exec("INSERT INTO orders (code,location_id,personal_name, delivery_date, customer_id,shipping_state_id, shipping_city_id, shipping_road, shipping_address1, status_code, delivery_time_range) VALUES (?,?, ?, ?, ?, ?, ?, ?, ?,?,?)",
-    newOrderReq.Code,newOrderReq.LocationID,newOrderReq.PersonalName, newOrderReq.DeliveryDate, newOrderReq.CustomerID,newOrderReq.ShippingStateID, newOrderReq.ShippingCityID, newOrderReq.ShippingRoad, newOrderReq.ShippingAddress1, newOrderReq.StatusCode, newOrderReq.DeliveryTimeRange)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    // 手機號碼是顧客之後取消或修改訂單的憑證，匯入的舊訂單可能沒有
+    if newOrderReq.Mobile == "" && !newOrderReq.Import {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供手機號碼"})
         return
     }
-    orderID, err := res.LastInsertId()
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+
+    // 新訂單一律為待付款，只有管理員 (例如已收款的電話訂單) 可以直接建立已付款的訂單
+    if newOrderReq.StatusCode == "" || !isAdminRequest(c) {
+        newOrderReq.StatusCode = orderPending
+    }
+    if newOrderReq.StatusCode != orderPending && newOrderReq.StatusCode != orderPaid {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "新訂單的狀態只能是 pending 或 paid"})
         return
     }
 
-    // 使用獲得的 order_id 插入主餐和附餐資料
-    for _, meal := range newOrderReq.OrderMeals {
-        // 插入主餐並獲得主餐ID
-        res, err := db.Exec("INSERT INTO order_products (order_id, product_id, name, quantity) VALUES (?, ?, ?, ?)",
-            orderID, meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity)
-        if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    // 超過截止時間不可下單，電話訂單由管理員略過
+    if newOrderReq.OverrideCutoff {
+        if !isAdminRequest(c) {
+            c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員可以略過截止時間"})
             return
         }
-        mainMealID, err := res.LastInsertId()
-        if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    } else if err := checkCutoff(newOrderReq.LocationID, newOrderReq.DeliveryDate, newOrderReq.DeliveryTimeRange); err != nil {
+        if err == errPastCutoff {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
             return
         }
+        log.Printf("checkCutoff error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查截止時間時發生錯誤"})
+        return
+    }
 
-        // 插入對應的附餐
-        for _, sideMeal := range meal.SideMeals {
-            _, err := db.Exec("INSERT INTO order_product_options (order_product_id, product_id, name, value, quantity) VALUES (?, ?, ?, ?, ?)",
-                mainMealID, sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity)
-            if err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-                return
-            }
+    // 依商品目錄檢查餐點並帶入名稱及價格
+    if err := applyCatalog(newOrderReq.OrderMeals, true); err != nil {
+        if errors.Is(err, errInvalidMeal) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
         }
+        log.Printf("applyCatalog error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查餐點時發生錯誤"})
+        return
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "新訂單創建成功", "order_id": orderID})
-}
-
-
+    // 地址所屬的外送區域，名額在 InsertOrder 的交易中檢查
+    zoneID, err := ResolveZoneID(newOrderReq.LocationID, newOrderReq.ShippingCityID, newOrderReq.ShippingRoad)
+    if err != nil {
+        log.Printf("ResolveZoneID error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查名額時發生錯誤"})
+        return
+    }
 
-// UpdateOrderMeal 處理更新訂單餐點的請求
-func UpdateOrderMeal(c *gin.Context) {
-    var updateMealReq NewOrderRequest
-    if err := c.BindJSON(&updateMealReq); err != nil {
+    // 金額一律由伺服器依商品目錄、外送區域及折扣碼計算
+    quote, err := priceOrder(db, newOrderReq.OrderMeals, zoneID, newOrderReq.DiscountCode, newOrderReq.DeliveryDate, true)
+    if err == errInvalidDiscount {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
+    if err != nil {
+        log.Printf("priceOrder error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法計算訂單金額"})
+        return
+    }
 
-    orderID, err := strconv.Atoi(c.Param("order_id"))
+    // 名額檢查、訂單、主餐、附餐、金額及保留轉換在同一個交易中寫入
+    order, err := InsertOrder(newOrderReq, zoneID, quote, requestActor(c, auditSourceAPI))
+    if err == errDuplicateOrderCode || err == errSlotFull || err == errZoneFull {
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    }
+    if err == errHoldInvalid {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的訂單 ID"})
+        log.Printf("InsertOrder error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "新訂單創建失敗"})
         return
     }
 
-    // 更新主餐
-    for _, meal := range updateMealReq.OrderMeals {
-        _, err = db.Exec("UPDATE order_products SET product_id = ?, name = ?, quantity = ? WHERE id = ? AND order_id = ?",
-            meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity, meal.MainMeal.ID, orderID)
+    c.JSON(http.StatusOK, gin.H{"message": "新訂單創建成功", "order_id": order.ID, "code": order.Code, "order": order})
+}
+
+// InsertOrder 在同一個交易中鎖定時段檢查名額，寫入訂單、主餐、附餐、金額及初始狀態紀錄，
+// 有保留憑證時一併轉為正式訂單，任何一步失敗都會整筆取消。匯入的舊訂單不檢查名額
+func InsertOrder(req NewOrderRequest, zoneID int, quote *OrderQuote, actor Actor) (*CreatedOrder, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if !req.Import {
+        if err := checkOrderCapacity(tx, req, zoneID); err != nil {
+            return nil, err
+        }
+    }
+
+    // 產生訂單編號，匯入時確認沿用的編號沒有重複
+    if req.Code == "" {
+        if req.Code, err = generateOrderCode(tx, req.LocationID); err != nil {
+            return nil, err
+        }
+    } else {
+        var exists bool
+        if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE code = ?)", req.Code).Scan(&exists); err != nil {
+            return nil, err
+        }
+        if exists {
+            return nil, errDuplicateOrderCode
+        }
+    }
+
+    // 插入訂單基本資料並獲取 order_id
+    res, err := tx.Exec("INSERT INTO orders (code,location_id,personal_name, mobile, delivery_date, customer_id,shipping_state_id, shipping_city_id, shipping_road, shipping_address1, status_code, delivery_time_range, zone_id) VALUES (?,?, ?, ?, ?, ?, ?, ?, ?, ?,?,?,?)",
+    req.Code,req.LocationID,req.PersonalName, req.Mobile, req.DeliveryDate, req.CustomerID,req.ShippingStateID, req.ShippingCityID, req.ShippingRoad, req.ShippingAddress1, req.StatusCode, req.DeliveryTimeRange, zoneID)
+    if err != nil {
+        return nil, err
+    }
+    orderID, err := res.LastInsertId()
+    if err != nil {
+        return nil, err
+    }
+
+    if err := recordOrderStatus(tx, orderID, "", req.StatusCode, actor, "建立訂單"); err != nil {
+        return nil, err
+    }
+    if err := saveOrderTotals(tx, orderID, quote); err != nil {
+        return nil, err
+    }
+
+    order := &CreatedOrder{
+        ID:                orderID,
+        Code:              req.Code,
+        LocationID:        req.LocationID,
+        DeliveryDate:      req.DeliveryDate,
+        DeliveryTimeRange: req.DeliveryTimeRange,
+        ZoneID:            zoneID,
+        StatusCode:        req.StatusCode,
+        Mobile:            req.Mobile,
+        OrderMeals:        make([]OrderMeal, 0, len(req.OrderMeals)),
+        Pricing:           quote,
+    }
+
+    // 使用獲得的 order_id 插入主餐和附餐資料
+    for i, meal := range req.OrderMeals {
+        // 插入主餐並獲得主餐ID
+        res, err := tx.Exec("INSERT INTO order_products (order_id, product_id, name, quantity, unit_price, sort_order) VALUES (?, ?, ?, ?, ?, ?)",
+            orderID, meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity, meal.MainMeal.UnitPrice, i)
         if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
+            return nil, err
         }
+        mainMealID, err := res.LastInsertId()
+        if err != nil {
+            return nil, err
+        }
+        meal.MainMeal.ID = int(mainMealID)
+        meal.MainMeal.OrderID = int(orderID)
 
-        // 更新每個主餐對應的副餐
+        // 插入對應的附餐
+        sideMeals := make([]OrderProductOption, 0, len(meal.SideMeals))
         for _, sideMeal := range meal.SideMeals {
-            if sideMeal.ID > 0 {
-                // 更新現有副餐
-                _, err = db.Exec("UPDATE order_product_options SET product_id = ?, name = ?, value = ?, quantity = ? WHERE id = ? AND order_product_id = ?",
-                    sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity, sideMeal.ID, meal.MainMeal.ID)
-                if err != nil {
-                    c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-                    return
-                }
-            } else {
-                // 新增副餐
-                _, err = db.Exec("INSERT INTO order_product_options (order_product_id, product_id, name, value, quantity) VALUES (?, ?, ?, ?, ?)",
-                    meal.MainMeal.ID, sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity)
-                if err != nil {
-                    c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-                    return
-                }
+            res, err := tx.Exec("INSERT INTO order_product_options (order_product_id, product_id, name, value, quantity, unit_price) VALUES (?, ?, ?, ?, ?, ?)",
+                mainMealID, sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity, sideMeal.UnitPrice)
+            if err != nil {
+                return nil, err
             }
+            sideMealID, err := res.LastInsertId()
+            if err != nil {
+                return nil, err
+            }
+            sideMeal.ID = int(sideMealID)
+            sideMeal.OrderProductID = int(mainMealID)
+            sideMeals = append(sideMeals, sideMeal)
+        }
+        meal.SideMeals = sideMeals
+        order.OrderMeals = append(order.OrderMeals, meal)
+    }
+
+    // 保留的名額轉為正式訂單
+    if req.HoldToken != "" {
+        if err := ConvertHold(tx, req.HoldToken, orderID); err != nil {
+            return nil, err
         }
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "訂單餐點更新成功"})
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return order, nil
 }
 
 
-// ForwardOrderToHTTPService 轉發
+
+// ForwardOrderToHTTPService 轉發付款資料到 POS。帶有 order_code 時金額一律改為訂單的總額，不採用前端送來的 amount；
+// 沒有 order_code 時與原本相同照原樣轉發
 func ForwardOrderToHTTPService(c *gin.Context) {
     // os導入環境變數
     baseURL := os.Getenv("POS_API_URL")
@@ -269,6 +354,42 @@ func ForwardOrderToHTTPService(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "無法讀取"})
         return
     }
+    contentType := c.GetHeader("Content-Type")
+
+    // 有 order_code 時金額由計價結果決定，只有待付款的訂單可以付款，其他欄位原樣轉發
+    var payload map[string]interface{}
+    if json.Unmarshal(body, &payload) == nil {
+        if value, ok := payload["order_code"]; ok {
+            orderCode, _ := value.(string)
+            if orderCode == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "訂單編號格式錯誤"})
+                return
+            }
+            total, status, err := FetchOrderTotal(orderCode)
+            if err == errOrderNotFound {
+                c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+                return
+            }
+            if err != nil {
+                log.Printf("FetchOrderTotal error: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "查詢訂單時發生錯誤"})
+                return
+            }
+            if status != orderPending {
+                c.JSON(http.StatusConflict, gin.H{"error": "訂單目前的狀態不需付款"})
+                return
+            }
+            payload["amount"] = total
+
+            body, err = json.Marshal(payload)
+            if err != nil {
+                log.Printf("Error encoding body: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "請求失敗"})
+                return
+            }
+            contentType = "application/json"
+        }
+    }
 
     // 轉發
     req, err := http.NewRequest("POST", fullURL, bytes.NewBuffer(body))
@@ -280,10 +401,15 @@ func ForwardOrderToHTTPService(c *gin.Context) {
 
 
     // 複製
-    req.Header.Set("Content-Type", c.GetHeader("Content-Type"))
+    req.Header.Set("Content-Type", contentType)
+    // 同一個 Idempotency-Key 一併交給 POS，讓 POS 也能辨識重送
+    if key := c.GetHeader("Idempotency-Key"); key != "" {
+        req.Header.Set("Idempotency-Key", key)
+    }
 
     // 轉發請其
     client := &http.Client{}
+    markUpstreamSent(c)
     resp, err := client.Do(req)
     if err != nil {
         log.Printf("Error sending request: %v", err)
@@ -0,0 +1,424 @@
+// orderMeals.go
+package api
+
+import (
+    "crypto/subtle"
+    "database/sql"
+    "errors"
+    "log"
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+)
+
+var (
+    errOrderNotFound    = errors.New("沒有該筆訂單")
+    errOrderNotEditable = errors.New("訂單目前的狀態不可修改餐點")
+    errMealNotInOrder   = errors.New("餐點不屬於這筆訂單")
+)
+
+// 可以修改餐點的訂單狀態，開始製作後就不能再改；
+// 已付款的訂單改價後沒有補收或退款的流程，也不能修改
+var editableOrderStatuses = map[string]bool{
+    orderPending:   true,
+    orderConfirmed: true,
+}
+
+// UpdateOrderMealsRequest 修改訂單餐點的請求，主餐依陣列順序排列，顧客修改時需提供下單的手機號碼
+type UpdateOrderMealsRequest struct {
+    OrderMeals []OrderMeal `json:"order_meals"`
+    Mobile     string      `json:"mobile"`
+}
+
+// UpdateOrderMeal 修改訂單餐點。PUT 以送出的內容取代整筆訂單的餐點，沒有送出的餐點會刪除；
+// PATCH 只處理送出的餐點，刪除需標記 delete。管理員以外需提供下單的手機號碼
+func UpdateOrderMeal(c *gin.Context) {
+    orderID, err := strconv.Atoi(c.Param("order_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的訂單 ID"})
+        return
+    }
+
+    var req UpdateOrderMealsRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    for _, meal := range req.OrderMeals {
+        if !meal.MainMeal.Delete && meal.MainMeal.Quantity <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "主餐數量必須大於 0"})
+            return
+        }
+    }
+    replace := c.Request.Method == http.MethodPut
+
+    // 依商品目錄檢查餐點並帶入名稱及價格；PATCH 只送出部分附餐，選項數量在交易中以修改後的餐點檢查
+    if err := applyCatalog(req.OrderMeals, replace); err != nil {
+        if errors.Is(err, errInvalidMeal) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        log.Printf("applyCatalog error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查餐點時發生錯誤"})
+        return
+    }
+
+    // 超過截止時間只有管理員可以修改
+    order, err := fetchOrderSlot(orderID)
+    if err == errOrderNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("fetchOrderSlot error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "查詢訂單時發生錯誤"})
+        return
+    }
+    if !isAdminRequest(c) {
+        // 與顧客取消訂單相同，手機號碼不符時回應查無訂單，避免以訂單 ID 修改他人訂單
+        if req.Mobile == "" || subtle.ConstantTimeCompare([]byte(req.Mobile), []byte(order.Mobile)) != 1 {
+            c.JSON(http.StatusNotFound, gin.H{"error": errOrderNotFound.Error()})
+            return
+        }
+        if err := checkCutoff(order.LocationID, order.DeliveryDate, order.DeliveryTimeRange); err == errPastCutoff {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        } else if err != nil {
+            log.Printf("checkCutoff error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查截止時間時發生錯誤"})
+            return
+        }
+    }
+
+    oldUnits, newUnits, err := EditOrderMeals(orderID, req.OrderMeals, replace)
+    switch err {
+    case nil:
+    case errOrderNotFound:
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    case errOrderNotEditable, errSlotFull:
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case errMealNotInOrder:
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    default:
+        if errors.Is(err, errInvalidMeal) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        log.Printf("EditOrderMeals error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "訂單餐點更新失敗，所有變更已取消"})
+        return
+    }
+
+    // 份數減少時釋出的名額提供給候補
+    if newUnits < oldUnits {
+        capacityFreed(order.LocationID, order.DeliveryDate, order.DeliveryTimeRange)
+    }
+
+    meals, err := fetchCompleteMeals(orderID)
+    if err != nil {
+        log.Printf("fetchCompleteMeals error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取訂單餐點"})
+        return
+    }
+
+    total, _, err := FetchOrderTotal(order.Code)
+    if err != nil {
+        log.Printf("FetchOrderTotal error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取訂單金額"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "訂單餐點更新成功", "order_meals": meals, "total": total})
+}
+
+// fetchOrderSlot 取得訂單的據點、日期、時段及狀態
+func fetchOrderSlot(orderID int) (*CreatedOrder, error) {
+    return lockOrderSlot(db, orderID, "")
+}
+
+// lockOrderSlot 取得訂單的據點、日期、時段、狀態及手機號碼，lock 可加上 FOR UPDATE
+func lockOrderSlot(q queryer, orderID int, lock string) (*CreatedOrder, error) {
+    order := CreatedOrder{ID: int64(orderID)}
+    err := q.QueryRow("SELECT code, location_id, DATE_FORMAT(delivery_date, '%Y-%m-%d'), delivery_time_range, status_code, COALESCE(mobile, '') FROM orders WHERE id = ? "+lock, orderID).Scan(
+        &order.Code, &order.LocationID, &order.DeliveryDate, &order.DeliveryTimeRange, &order.StatusCode, &order.Mobile)
+    if err == sql.ErrNoRows {
+        return nil, errOrderNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// EditOrderMeals 在同一個交易中比對送出的餐點與資料庫，新增、修改及刪除主餐與附餐，
+// 份數增加時重新檢查時段上限並重新計算金額，回傳修改前後的份數。
+// PUT 依送出的順序重新排列；PATCH 保留修改的主餐原本的順序，新增的主餐排在最後
+func EditOrderMeals(orderID int, meals []OrderMeal, replace bool) (int, int, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, 0, err
+    }
+    defer tx.Rollback()
+
+    order, err := lockOrderSlot(tx, orderID, "FOR UPDATE")
+    if err != nil {
+        return 0, 0, err
+    }
+    if !editableOrderStatuses[order.StatusCode] {
+        return 0, 0, errOrderNotEditable
+    }
+
+    existing, err := lockOrderMeals(tx, orderID)
+    if err != nil {
+        return 0, 0, err
+    }
+    oldUnits := 0
+    for _, meal := range existing {
+        oldUnits += meal.MainMeal.Quantity
+    }
+
+    nextSort := 0
+    if !replace {
+        if err := tx.QueryRow("SELECT COALESCE(MAX(sort_order), -1) + 1 FROM order_products WHERE order_id = ?", orderID).Scan(&nextSort); err != nil {
+            return 0, 0, err
+        }
+    }
+
+    kept := make(map[int]bool)
+    for i, meal := range meals {
+        mainID := meal.MainMeal.ID
+        if mainID > 0 {
+            current, ok := existing[mainID]
+            if !ok {
+                return 0, 0, errMealNotInOrder
+            }
+            if meal.MainMeal.Delete {
+                if err := deleteOrderProduct(tx, mainID); err != nil {
+                    return 0, 0, err
+                }
+                continue
+            }
+            var err error
+            if replace {
+                _, err = tx.Exec("UPDATE order_products SET product_id = ?, name = ?, quantity = ?, unit_price = ?, sort_order = ? WHERE id = ?",
+                    meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity, meal.MainMeal.UnitPrice, i, mainID)
+            } else {
+                _, err = tx.Exec("UPDATE order_products SET product_id = ?, name = ?, quantity = ?, unit_price = ? WHERE id = ?",
+                    meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity, meal.MainMeal.UnitPrice, mainID)
+            }
+            if err != nil {
+                return 0, 0, err
+            }
+            // 換成其他商品時原本的附餐不再適用，沒有重新送出的附餐一併刪除
+            replaceSides := replace || meal.MainMeal.ProductID != current.MainMeal.ProductID
+            if err := editSideMeals(tx, mainID, current.SideMeals, meal.SideMeals, replaceSides); err != nil {
+                return 0, 0, err
+            }
+        } else {
+            if meal.MainMeal.Delete {
+                continue
+            }
+            sortOrder := i
+            if !replace {
+                sortOrder = nextSort
+                nextSort++
+            }
+            res, err := tx.Exec("INSERT INTO order_products (order_id, product_id, name, quantity, unit_price, sort_order) VALUES (?, ?, ?, ?, ?, ?)",
+                orderID, meal.MainMeal.ProductID, meal.MainMeal.Name, meal.MainMeal.Quantity, meal.MainMeal.UnitPrice, sortOrder)
+            if err != nil {
+                return 0, 0, err
+            }
+            newID, err := res.LastInsertId()
+            if err != nil {
+                return 0, 0, err
+            }
+            mainID = int(newID)
+            if err := editSideMeals(tx, mainID, nil, meal.SideMeals, replace); err != nil {
+                return 0, 0, err
+            }
+        }
+        kept[mainID] = true
+    }
+
+    // PUT 時沒有送出的主餐一併刪除
+    if replace {
+        for id := range existing {
+            if !kept[id] {
+                if err := deleteOrderProduct(tx, id); err != nil {
+                    return 0, 0, err
+                }
+            }
+        }
+    }
+
+    // PATCH 時以修改後的主餐及附餐檢查選項數量
+    if !replace {
+        final, err := lockOrderMeals(tx, orderID)
+        if err != nil {
+            return 0, 0, err
+        }
+        var edited []*OrderMeal
+        for id := range kept {
+            edited = append(edited, final[id])
+        }
+        if err := checkMealGroups(edited); err != nil {
+            return 0, 0, err
+        }
+    }
+
+    var newUnits int
+    if err := tx.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM order_products WHERE order_id = ?", orderID).Scan(&newUnits); err != nil {
+        return 0, 0, err
+    }
+
+    // 份數增加時鎖定時段上限並重新計算，交易中的已訂份數已包含這次的修改；
+    // 沒有設定時段上限時與新訂單相同依 requireSlotLimit 處理
+    if newUnits > oldUnits {
+        limit, limited, err := lockSlotCapacity(tx, order.LocationID, order.DeliveryDate, order.DeliveryTimeRange)
+        if err != nil {
+            return 0, 0, err
+        }
+        if limited {
+            booked, err := bookedUnits(tx, order.LocationID, order.DeliveryDate, order.DeliveryTimeRange)
+            if err != nil {
+                return 0, 0, err
+            }
+            held, err := heldUnits(tx, order.LocationID, order.DeliveryDate, order.DeliveryTimeRange, "")
+            if err != nil {
+                return 0, 0, err
+            }
+            if booked+held > limit {
+                return 0, 0, errSlotFull
+            }
+        }
+    }
+
+    // 依修改後的餐點重新計算訂單金額
+    if _, err := repriceOrder(tx, orderID); err != nil {
+        return 0, 0, err
+    }
+
+    return oldUnits, newUnits, tx.Commit()
+}
+
+// editSideMeals 比對主餐底下送出的附餐與現有附餐，replace 時沒有送出的附餐會刪除
+func editSideMeals(tx *sql.Tx, mainID int, existing []OrderProductOption, sideMeals []OrderProductOption, replace bool) error {
+    current := make(map[int]bool)
+    for _, option := range existing {
+        current[option.ID] = true
+    }
+
+    kept := make(map[int]bool)
+    for _, sideMeal := range sideMeals {
+        if sideMeal.ID > 0 {
+            if !current[sideMeal.ID] {
+                return errMealNotInOrder
+            }
+            if sideMeal.Delete {
+                if _, err := tx.Exec("DELETE FROM order_product_options WHERE id = ?", sideMeal.ID); err != nil {
+                    return err
+                }
+                continue
+            }
+            _, err := tx.Exec("UPDATE order_product_options SET product_id = ?, name = ?, value = ?, quantity = ?, unit_price = ? WHERE id = ?",
+                sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity, sideMeal.UnitPrice, sideMeal.ID)
+            if err != nil {
+                return err
+            }
+            kept[sideMeal.ID] = true
+            continue
+        }
+        if sideMeal.Delete {
+            continue
+        }
+        _, err := tx.Exec("INSERT INTO order_product_options (order_product_id, product_id, name, value, quantity, unit_price) VALUES (?, ?, ?, ?, ?, ?)",
+            mainID, sideMeal.ProductID, sideMeal.Name, sideMeal.Value, sideMeal.Quantity, sideMeal.UnitPrice)
+        if err != nil {
+            return err
+        }
+    }
+
+    if replace {
+        for id := range current {
+            if !kept[id] {
+                if _, err := tx.Exec("DELETE FROM order_product_options WHERE id = ?", id); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// deleteOrderProduct 刪除主餐及其附餐
+func deleteOrderProduct(tx *sql.Tx, mainID int) error {
+    if _, err := tx.Exec("DELETE FROM order_product_options WHERE order_product_id = ?", mainID); err != nil {
+        return err
+    }
+    _, err := tx.Exec("DELETE FROM order_products WHERE id = ?", mainID)
+    return err
+}
+
+// lockOrderMeals 鎖定並取得訂單現有的主餐及附餐，以主餐 ID 為 key
+func lockOrderMeals(tx *sql.Tx, orderID int) (map[int]*OrderMeal, error) {
+    rows, err := tx.Query(`SELECT p.id, p.product_id, p.name, p.quantity, o.id, o.product_id, o.name, o.value, o.quantity
+        FROM order_products p LEFT JOIN order_product_options o ON o.order_product_id = p.id
+        WHERE p.order_id = ? FOR UPDATE`, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    meals := make(map[int]*OrderMeal)
+    for rows.Next() {
+        var product OrderProduct
+        var optionID, optionProductID sql.NullInt64
+        var optionName, optionValue sql.NullString
+        var optionQuantity sql.NullFloat64
+        if err := rows.Scan(&product.ID, &product.ProductID, &product.Name, &product.Quantity,
+            &optionID, &optionProductID, &optionName, &optionValue, &optionQuantity); err != nil {
+            return nil, err
+        }
+
+        meal, ok := meals[product.ID]
+        if !ok {
+            product.OrderID = orderID
+            meal = &OrderMeal{MainMeal: product}
+            meals[product.ID] = meal
+        }
+        if optionID.Valid {
+            meal.SideMeals = append(meal.SideMeals, OrderProductOption{
+                ID:             int(optionID.Int64),
+                OrderProductID: product.ID,
+                ProductID:      int(optionProductID.Int64),
+                Name:           optionName.String,
+                Value:          optionValue.String,
+                Quantity:       optionQuantity.Float64,
+            })
+        }
+    }
+
+    return meals, rows.Err()
+}
+
+// fetchCompleteMeals 取得訂單的主餐及附餐
+func fetchCompleteMeals(orderID int) ([]CompleteMeal, error) {
+    mainMeals, err := FetchOrderProducts(orderID)
+    if err != nil {
+        return nil, err
+    }
+
+    completeMeals := []CompleteMeal{}
+    for _, mainMeal := range mainMeals {
+        sideMeals, err := FetchOrderProductOptions(mainMeal.ID)
+        if err != nil {
+            return nil, err
+        }
+        completeMeals = append(completeMeals, CompleteMeal{MainMeal: mainMeal, SideMeals: sideMeals})
+    }
+    return completeMeals, nil
+}
@@ -0,0 +1,237 @@
+// orderSearch.go
+package api
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 後台訂單列表預設及最大的每頁筆數
+const (
+    defaultOrderPageSize = 20
+    maxOrderPageSize     = 200
+)
+
+// orderColumns 查詢訂單時選取的欄位，順序與 fetchOrders 相同
+const orderColumns = "id, code, location_id, personal_name, DATE_FORMAT(delivery_date, '%Y-%m-%d'), shipping_state_id, shipping_city_id, shipping_road, shipping_address1, status_code, delivery_time_range, mobile, shipping_status, subtotal, discount, delivery_fee, total"
+
+// orderFilterColumns 可以完全比對的查詢參數及對應的欄位，不在清單中的參數一律忽略
+var orderFilterColumns = map[string]string{
+    "code":          "code",
+    "mobile":        "mobile",
+    "personal_name": "personal_name",
+    "location_id":   "location_id",
+    "time_slot":     "delivery_time_range",
+    "status":        "status_code",
+    "city_id":       "shipping_city_id",
+    "road":          "shipping_road",
+}
+
+// orderSortColumns 可以排序的欄位，前面加上 - 表示遞減
+var orderSortColumns = map[string]string{
+    "id":            "id",
+    "code":          "code",
+    "delivery_date": "delivery_date",
+    "time_slot":     "delivery_time_range",
+    "personal_name": "personal_name",
+    "status":        "status_code",
+    "total":         "total",
+}
+
+// orderQuery 組合訂單查詢的條件，欄位名稱只來自白名單，值一律用參數帶入
+type orderQuery struct {
+    where []string
+    args  []interface{}
+}
+
+// eq 完全比對，key 須在 orderFilterColumns 中
+func (q *orderQuery) eq(key, value string) error {
+    column, ok := orderFilterColumns[key]
+    if !ok {
+        return fmt.Errorf("不支援的查詢條件: %s", key)
+    }
+    q.where = append(q.where, column+" = ?")
+    q.args = append(q.args, value)
+    return nil
+}
+
+// contains 部分比對姓名或手機，% 與 _ 視為一般字元
+func (q *orderQuery) contains(value string, columns ...string) {
+    escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+    var parts []string
+    for _, column := range columns {
+        parts = append(parts, column+" LIKE ?")
+        q.args = append(q.args, "%"+escaped+"%")
+    }
+    q.where = append(q.where, "("+strings.Join(parts, " OR ")+")")
+}
+
+// cond 加入固定的條件
+func (q *orderQuery) cond(where string, args ...interface{}) {
+    q.where = append(q.where, where)
+    q.args = append(q.args, args...)
+}
+
+func (q *orderQuery) whereClause() string {
+    if len(q.where) == 0 {
+        return ""
+    }
+    return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// OrderSearchResult 後台訂單列表的一頁結果
+type OrderSearchResult struct {
+    Total    int     `json:"total"`
+    Page     int     `json:"page"`
+    PageSize int     `json:"page_size"`
+    Orders   []Order `json:"orders"`
+}
+
+// SearchOrders 後台訂單列表，可依日期範圍、時段、狀態、城市、路名、據點篩選，
+// 以 q (或 name、mobile) 部分比對姓名及手機，支援排序及分頁
+func SearchOrders(c *gin.Context) {
+    query, err := buildOrderSearch(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    orderBy, err := parseOrderSort(c.DefaultQuery("sort", "-delivery_date"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    page, pageSize, err := parsePage(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    result, err := FetchOrderPage(query, orderBy, page, pageSize)
+    if err != nil {
+        log.Printf("FetchOrderPage error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法查詢訂單"})
+        return
+    }
+
+    c.JSON(http.StatusOK, result)
+}
+
+// buildOrderSearch 從查詢參數組合條件
+func buildOrderSearch(c *gin.Context) (*orderQuery, error) {
+    query := &orderQuery{}
+
+    for _, key := range []string{"code", "location_id", "time_slot", "status", "city_id", "road"} {
+        if value := c.Query(key); value != "" {
+            query.eq(key, value)
+        }
+    }
+
+    startDate, endDate := c.Query("start_date"), c.Query("end_date")
+    if startDate != "" || endDate != "" {
+        if startDate == "" {
+            startDate = endDate
+        }
+        if endDate == "" {
+            endDate = startDate
+        }
+        if _, _, err := parseDateRange(startDate, endDate); err != nil {
+            return nil, err
+        }
+        query.cond("delivery_date BETWEEN ? AND ?", startDate, endDate)
+    }
+
+    if q := strings.TrimSpace(c.Query("q")); q != "" {
+        query.contains(q, "personal_name", "mobile")
+    }
+    if name := strings.TrimSpace(c.Query("name")); name != "" {
+        query.contains(name, "personal_name")
+    }
+    if mobile := strings.TrimSpace(c.Query("mobile")); mobile != "" {
+        query.contains(mobile, "mobile")
+    }
+
+    return query, nil
+}
+
+// parseOrderSort 解析排序參數，例如 -delivery_date,time_slot，最後都以 id 排序讓分頁穩定
+func parseOrderSort(sort string) (string, error) {
+    var parts []string
+    for _, field := range strings.Split(sort, ",") {
+        field = strings.TrimSpace(field)
+        if field == "" {
+            continue
+        }
+        direction := "ASC"
+        if strings.HasPrefix(field, "-") {
+            direction = "DESC"
+            field = field[1:]
+        }
+        column, ok := orderSortColumns[field]
+        if !ok {
+            return "", fmt.Errorf("不支援的排序欄位: %s", field)
+        }
+        parts = append(parts, column+" "+direction)
+    }
+    parts = append(parts, "id DESC")
+    return strings.Join(parts, ", "), nil
+}
+
+// parsePage 讀取 page (從 1 開始) 及 page_size
+func parsePage(c *gin.Context) (int, int, error) {
+    page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+    if err != nil || page < 1 {
+        return 0, 0, errors.New("無效的頁數")
+    }
+    pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultOrderPageSize)))
+    if err != nil || pageSize < 1 || pageSize > maxOrderPageSize {
+        return 0, 0, fmt.Errorf("每頁筆數需介於 1 到 %d", maxOrderPageSize)
+    }
+    return page, pageSize, nil
+}
+
+// FetchOrderPage 依條件取得總筆數及一頁訂單
+func FetchOrderPage(query *orderQuery, orderBy string, page, pageSize int) (*OrderSearchResult, error) {
+    result := &OrderSearchResult{Page: page, PageSize: pageSize, Orders: []Order{}}
+    if err := db.QueryRow("SELECT COUNT(*) FROM orders"+query.whereClause(), query.args...).Scan(&result.Total); err != nil {
+        return nil, err
+    }
+    if result.Total == 0 {
+        return result, nil
+    }
+
+    args := append(append([]interface{}{}, query.args...), pageSize, (page-1)*pageSize)
+    orders, err := fetchOrders("SELECT "+orderColumns+" FROM orders"+query.whereClause()+" ORDER BY "+orderBy+" LIMIT ? OFFSET ?", args...)
+    if err != nil {
+        return nil, err
+    }
+    result.Orders = orders
+    return result, nil
+}
+
+// fetchOrders 執行選取 orderColumns 的查詢
+func fetchOrders(query string, args ...interface{}) ([]Order, error) {
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orders []Order
+    for rows.Next() {
+        var order Order
+        if err := rows.Scan(&order.OrderID, &order.Code, &order.LocationID, &order.PersonalName, &order.DeliveryDate, &order.ShippingStateID, &order.ShippingCityID,
+            &order.ShippingRoad, &order.ShippingAddress1, &order.StatusCode, &order.DeliveryTimeRange, &order.Mobile, &order.ShippingStatus,
+            &order.Subtotal, &order.Discount, &order.DeliveryFee, &order.Total); err != nil {
+            return nil, err
+        }
+        orders = append(orders, order)
+    }
+
+    return orders, rows.Err()
+}
@@ -0,0 +1,231 @@
+// orderStatus.go
+package api
+
+import (
+    "crypto/subtle"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 訂單狀態
+const (
+    orderPending        = "pending"
+    orderPaid           = "paid"
+    orderConfirmed      = "confirmed"
+    orderPreparing      = "preparing"
+    orderOutForDelivery = "out_for_delivery"
+    orderDelivered      = "delivered"
+    orderCancelled      = "cancelled"
+    orderRefunded       = "refunded"
+)
+
+// releasedStatuses 不佔名額的狀態，用在統計已訂份數的 SQL
+const releasedStatuses = "'cancelled', 'refunded'"
+
+// orderTransitions 每個狀態允許轉換到的狀態，cancelled 與 refunded 為終點
+var orderTransitions = map[string][]string{
+    orderPending:        {orderPaid, orderConfirmed, orderCancelled},
+    orderPaid:           {orderConfirmed, orderCancelled, orderRefunded},
+    orderConfirmed:      {orderPreparing, orderCancelled, orderRefunded},
+    orderPreparing:      {orderOutForDelivery},
+    orderOutForDelivery: {orderDelivered},
+    orderDelivered:      {orderRefunded},
+    orderCancelled:      {},
+    orderRefunded:       {},
+}
+
+var errInvalidTransition = errors.New("訂單目前的狀態不可轉換成指定的狀態")
+
+// OrderStatusChange 表示一次訂單狀態轉換
+type OrderStatusChange struct {
+    ID         int64  `json:"id"`
+    OrderID    int    `json:"order_id"`
+    FromStatus string `json:"from_status"`
+    ToStatus   string `json:"to_status"`
+    Actor      string `json:"actor"`
+    Source     string `json:"source"`
+    Reason     string `json:"reason"`
+    CreatedAt  string `json:"created_at"`
+}
+
+// OrderTransitionRequest 轉換訂單狀態的請求，顧客取消自己的訂單時需提供下單的手機號碼
+type OrderTransitionRequest struct {
+    Status string `json:"status"`
+    Reason string `json:"reason"`
+    Mobile string `json:"mobile"`
+}
+
+// validOrderStatus 是否為定義的狀態
+func validOrderStatus(status string) bool {
+    _, ok := orderTransitions[status]
+    return ok
+}
+
+// canTransition 是否允許從 from 轉換到 to
+func canTransition(from, to string) bool {
+    for _, next := range orderTransitions[from] {
+        if next == to {
+            return true
+        }
+    }
+    return false
+}
+
+// isReleasedStatus 訂單是否已不佔名額
+func isReleasedStatus(status string) bool {
+    return status == orderCancelled || status == orderRefunded
+}
+
+// recordOrderStatus 寫入狀態轉換紀錄
+func recordOrderStatus(q execer, orderID int64, from, to string, actor Actor, reason string) error {
+    _, err := q.Exec("INSERT INTO order_status_history (order_id, from_status, to_status, actor, source, reason) VALUES (?, ?, ?, ?, ?, ?)",
+        orderID, from, to, actor.Name, actor.Source, reason)
+    return err
+}
+
+// TransitionOrderStatus 在交易中鎖定訂單、檢查轉換是否允許，更新狀態並記錄，回傳原本的狀態
+func TransitionOrderStatus(orderID int, to, reason string, actor Actor) (string, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return "", err
+    }
+    defer tx.Rollback()
+
+    var from string
+    if err := tx.QueryRow("SELECT status_code FROM orders WHERE id = ? FOR UPDATE", orderID).Scan(&from); err != nil {
+        return "", err
+    }
+    if !canTransition(from, to) {
+        return from, errInvalidTransition
+    }
+
+    if _, err := tx.Exec("UPDATE orders SET status_code = ? WHERE id = ?", to, orderID); err != nil {
+        return from, err
+    }
+    if err := recordOrderStatus(tx, int64(orderID), from, to, actor, reason); err != nil {
+        return from, err
+    }
+
+    return from, tx.Commit()
+}
+
+// TransitionOrderStatusHandler 依訂單編號轉換訂單狀態，取消或退款後釋出的名額提供給候補。
+// 管理員及 POS 回呼可以轉換狀態 (退款只限管理員)，顧客只能以下單的手機號碼取消自己的訂單
+func TransitionOrderStatusHandler(c *gin.Context) {
+    orderCode := c.Param("code")
+    if !validOrderCode(orderCode) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無此訂單編號"})
+        return
+    }
+
+    var req OrderTransitionRequest
+    if err := c.ShouldBindJSON(&req); err != nil || !validOrderStatus(req.Status) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的訂單狀態"})
+        return
+    }
+    // 退款只有管理員可以操作，其他狀態由管理員或 POS 回呼轉換，顧客只能取消
+    privileged := isAdminRequest(c) || isPOSRequest(c)
+    if req.Status == orderRefunded && !isAdminRequest(c) {
+        c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員可以退款"})
+        return
+    }
+    if !privileged && req.Status != orderCancelled {
+        c.JSON(http.StatusForbidden, gin.H{"error": "只有管理員可以變更訂單狀態"})
+        return
+    }
+
+    // 訂單是否存在
+    var orderID, locationID int
+    var deliveryDate, timeSlot, mobile string
+    err := db.QueryRow("SELECT id, location_id, DATE_FORMAT(delivery_date, '%Y-%m-%d'), delivery_time_range, COALESCE(mobile, '') FROM orders WHERE code = ?", orderCode).Scan(&orderID, &locationID, &deliveryDate, &timeSlot, &mobile)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            c.JSON(http.StatusNotFound, gin.H{"error": "沒有該筆訂單"})
+        } else {
+            log.Printf("Query error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "查詢訂單時發生錯誤"})
+        }
+        return
+    }
+    // 顧客取消需提供下單的手機號碼，不符時與查無訂單相同回應，避免以訂單編號猜測他人訂單
+    if !privileged && (req.Mobile == "" || subtle.ConstantTimeCompare([]byte(req.Mobile), []byte(mobile)) != 1) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有該筆訂單"})
+        return
+    }
+
+    from, err := TransitionOrderStatus(orderID, req.Status, req.Reason, requestActor(c, auditSourceAPI))
+    if err == errInvalidTransition {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "from":    from,
+            "to":      req.Status,
+            "allowed": orderTransitions[from],
+        })
+        return
+    }
+    if err != nil {
+        log.Printf("TransitionOrderStatus error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法更新訂單狀態"})
+        return
+    }
+
+    if isReleasedStatus(req.Status) && !isReleasedStatus(from) {
+        capacityFreed(locationID, deliveryDate, timeSlot)
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "message": fmt.Sprintf("訂單狀態已從 %s 更新為 %s", from, req.Status),
+        "from":    from,
+        "to":      req.Status,
+    })
+}
+
+// GetOrderStatusHistory 依訂單編號取得狀態轉換紀錄
+func GetOrderStatusHistory(c *gin.Context) {
+    var orderID int
+    err := db.QueryRow("SELECT id FROM orders WHERE code = ?", c.Param("code")).Scan(&orderID)
+    if err == sql.ErrNoRows {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有該筆訂單"})
+        return
+    }
+    if err != nil {
+        log.Printf("Query error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "查詢訂單時發生錯誤"})
+        return
+    }
+
+    history, err := FetchOrderStatusHistory(orderID)
+    if err != nil {
+        log.Printf("FetchOrderStatusHistory error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取狀態紀錄"})
+        return
+    }
+
+    c.JSON(http.StatusOK, history)
+}
+
+// FetchOrderStatusHistory 依時間順序取得訂單的狀態轉換紀錄
+func FetchOrderStatusHistory(orderID int) ([]OrderStatusChange, error) {
+    rows, err := db.Query(`SELECT id, order_id, from_status, to_status, actor, source, reason, DATE_FORMAT(created_at, '%Y-%m-%d %H:%i:%s')
+        FROM order_status_history WHERE order_id = ? ORDER BY id`, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    history := []OrderStatusChange{}
+    for rows.Next() {
+        var change OrderStatusChange
+        if err := rows.Scan(&change.ID, &change.OrderID, &change.FromStatus, &change.ToStatus, &change.Actor, &change.Source, &change.Reason, &change.CreatedAt); err != nil {
+            return nil, err
+        }
+        history = append(history, change)
+    }
+
+    return history, rows.Err()
+}
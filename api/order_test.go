@@ -0,0 +1,263 @@
+// order_test.go
+package api
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "io"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeOrderDB 以 database/sql/driver 模擬資料庫，記錄交易的提交及取消，
+// 並讓第 failAt 次以 failOn 開頭的語句失敗
+type fakeOrderDB struct {
+    mu        sync.Mutex
+    failOn    string
+    failAt    int
+    holdRows  int64 // UPDATE CapacityHolds 影響的筆數
+    seen      map[string]int
+    nextID    int64
+    commits   int
+    rollbacks int
+}
+
+var errFakeExec = errors.New("fake exec failed")
+
+var (
+    fakeDriverOnce sync.Once
+    currentFakeDB  *fakeOrderDB
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+    return &fakeConn{db: currentFakeDB}, nil
+}
+
+type fakeConn struct {
+    db *fakeOrderDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+    return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+    return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+    return &fakeTx{db: c.db}, nil
+}
+
+type fakeTx struct {
+    db *fakeOrderDB
+}
+
+func (t *fakeTx) Commit() error {
+    t.db.mu.Lock()
+    defer t.db.mu.Unlock()
+    t.db.commits++
+    return nil
+}
+
+func (t *fakeTx) Rollback() error {
+    t.db.mu.Lock()
+    defer t.db.mu.Unlock()
+    t.db.rollbacks++
+    return nil
+}
+
+type fakeStmt struct {
+    db    *fakeOrderDB
+    query string
+}
+
+func (s *fakeStmt) Close() error {
+    return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+    return -1
+}
+
+// fail 計算語句出現的次數，符合設定時回傳錯誤
+func (d *fakeOrderDB) fail(query string) error {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.failOn == "" || !strings.HasPrefix(query, d.failOn) {
+        return nil
+    }
+    d.seen[d.failOn]++
+    if d.seen[d.failOn] == d.failAt {
+        return errFakeExec
+    }
+    return nil
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+    if err := s.db.fail(s.query); err != nil {
+        return nil, err
+    }
+    s.db.mu.Lock()
+    defer s.db.mu.Unlock()
+    s.db.nextID++
+    affected := int64(1)
+    if strings.HasPrefix(s.query, "UPDATE CapacityHolds") {
+        affected = s.db.holdRows
+    }
+    return fakeResult{id: s.db.nextID, affected: affected}, nil
+}
+
+// Query 只有匯入時確認訂單編號是否重複的查詢，一律回傳不存在
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+    if err := s.db.fail(s.query); err != nil {
+        return nil, err
+    }
+    return &fakeRows{values: [][]driver.Value{{false}}}, nil
+}
+
+type fakeResult struct {
+    id       int64
+    affected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+    return r.id, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+    return r.affected, nil
+}
+
+type fakeRows struct {
+    values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+    return []string{"exists"}
+}
+
+func (r *fakeRows) Close() error {
+    return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+    if len(r.values) == 0 {
+        return io.EOF
+    }
+    copy(dest, r.values[0])
+    r.values = r.values[1:]
+    return nil
+}
+
+// useFakeOrderDB 將全域的 db 換成模擬資料庫，測試結束後還原
+func useFakeOrderDB(t *testing.T, fake *fakeOrderDB) {
+    fakeDriverOnce.Do(func() {
+        sql.Register("fakeorder", fakeDriver{})
+    })
+    currentFakeDB = fake
+
+    conn, err := sql.Open("fakeorder", "")
+    if err != nil {
+        t.Fatalf("sql.Open: %v", err)
+    }
+    original := db
+    db = conn
+    t.Cleanup(func() {
+        conn.Close()
+        db = original
+    })
+}
+
+// testOrderRequest 匯入的訂單 (略過名額檢查及產生編號)，兩個主餐共三個附餐並使用保留憑證
+func testOrderRequest() NewOrderRequest {
+    return NewOrderRequest{
+        Code:              "TEST-1",
+        LocationID:        1,
+        PersonalName:      "測試",
+        Mobile:            "0912345678",
+        DeliveryDate:      "2024-01-02",
+        StatusCode:        orderPending,
+        DeliveryTimeRange: "11:00-12:00",
+        HoldToken:         "token",
+        Import:            true,
+        OrderMeals: []OrderMeal{
+            {
+                MainMeal: OrderProduct{ProductID: 1, Name: "雞腿便當", Quantity: 2, UnitPrice: 100},
+                SideMeals: []OrderProductOption{
+                    {ProductID: 11, Name: "飲料", Value: "紅茶", Quantity: 1},
+                    {ProductID: 12, Name: "加點", Value: "加蛋", Quantity: 1, UnitPrice: 10},
+                },
+            },
+            {
+                MainMeal: OrderProduct{ProductID: 2, Name: "排骨便當", Quantity: 1, UnitPrice: 90},
+                SideMeals: []OrderProductOption{
+                    {ProductID: 11, Name: "飲料", Value: "綠茶", Quantity: 1},
+                },
+            },
+        },
+    }
+}
+
+func TestInsertOrderCommits(t *testing.T) {
+    fake := &fakeOrderDB{holdRows: 1, seen: make(map[string]int)}
+    useFakeOrderDB(t, fake)
+
+    order, err := InsertOrder(testOrderRequest(), 0, &OrderQuote{Total: 300}, schedulerActor)
+    if err != nil {
+        t.Fatalf("InsertOrder: %v", err)
+    }
+    if len(order.OrderMeals) != 2 || len(order.OrderMeals[0].SideMeals) != 2 || len(order.OrderMeals[1].SideMeals) != 1 {
+        t.Fatalf("unexpected meals: %+v", order.OrderMeals)
+    }
+    if fake.commits != 1 {
+        t.Fatalf("commits = %d, want 1", fake.commits)
+    }
+}
+
+func TestInsertOrderRollsBack(t *testing.T) {
+    tests := []struct {
+        name     string
+        failOn   string
+        failAt   int
+        holdRows int64
+        wantErr  error
+    }{
+        {name: "order", failOn: "INSERT INTO orders", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "first meal", failOn: "INSERT INTO order_products", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "second meal", failOn: "INSERT INTO order_products", failAt: 2, holdRows: 1, wantErr: errFakeExec},
+        {name: "first option", failOn: "INSERT INTO order_product_options", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "second option", failOn: "INSERT INTO order_product_options", failAt: 2, holdRows: 1, wantErr: errFakeExec},
+        {name: "option of second meal", failOn: "INSERT INTO order_product_options", failAt: 3, holdRows: 1, wantErr: errFakeExec},
+        {name: "status history", failOn: "INSERT INTO order_status_history", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "totals", failOn: "UPDATE orders SET subtotal", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "hold conversion", failOn: "UPDATE CapacityHolds", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+        {name: "hold expired", holdRows: 0, wantErr: errHoldInvalid},
+        {name: "waitlist offer", failOn: "UPDATE Waitlist", failAt: 1, holdRows: 1, wantErr: errFakeExec},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := &fakeOrderDB{failOn: tt.failOn, failAt: tt.failAt, holdRows: tt.holdRows, seen: make(map[string]int)}
+            useFakeOrderDB(t, fake)
+
+            order, err := InsertOrder(testOrderRequest(), 0, &OrderQuote{Total: 300}, schedulerActor)
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("err = %v, want %v", err, tt.wantErr)
+            }
+            if order != nil {
+                t.Fatalf("order = %+v, want nil", order)
+            }
+            if fake.commits != 0 {
+                t.Fatalf("commits = %d, want 0", fake.commits)
+            }
+            if fake.rollbacks != 1 {
+                t.Fatalf("rollbacks = %d, want 1", fake.rollbacks)
+            }
+        })
+    }
+}
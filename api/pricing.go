@@ -0,0 +1,386 @@
+// pricing.go
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "log"
+    "math"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 預設稅率 (百分比)，價格為含稅價
+const defaultTaxRate = 5
+
+// 折扣碼類型
+const (
+    discountPercent = "percent"
+    discountAmount  = "amount"
+)
+
+var errInvalidDiscount = errors.New("折扣碼無效或不符合使用條件")
+
+// DiscountCode 表示 discount_codes 表的結構
+type DiscountCode struct {
+    Code        string  `json:"code"`
+    Kind        string  `json:"kind"`
+    Value       int     `json:"value"`
+    MinSubtotal int     `json:"min_subtotal"`
+    StartsOn    *string `json:"starts_on"`
+    EndsOn      *string `json:"ends_on"`
+    Active      bool    `json:"active"`
+}
+
+// QuoteLine 每個主餐的金額，OptionsPrice 為每份主餐的選項加價
+type QuoteLine struct {
+    ProductID    int    `json:"product_id"`
+    Name         string `json:"name"`
+    Quantity     int    `json:"quantity"`
+    UnitPrice    int    `json:"unit_price"`
+    OptionsPrice int    `json:"options_price"`
+    LineTotal    int    `json:"line_total"`
+}
+
+// OrderQuote 計價結果，Total = Subtotal - Discount + DeliveryFee，Tax 為 Total 中包含的稅額
+type OrderQuote struct {
+    Lines        []QuoteLine `json:"lines"`
+    Subtotal     int         `json:"subtotal"`
+    DiscountCode string      `json:"discount_code"`
+    Discount     int         `json:"discount"`
+    DeliveryFee  int         `json:"delivery_fee"`
+    Tax          int         `json:"tax"`
+    Total        int         `json:"total"`
+}
+
+// taxRate 從環境變數 ORDER_TAX_RATE 讀取稅率 (百分比)
+func taxRate() int {
+    rate, err := strconv.Atoi(os.Getenv("ORDER_TAX_RATE"))
+    if err != nil || rate < 0 {
+        return defaultTaxRate
+    }
+    return rate
+}
+
+// includedTax 含稅金額中包含的稅額
+func includedTax(total int) int {
+    rate := taxRate()
+    if rate == 0 || total <= 0 {
+        return 0
+    }
+    return total - int(math.Round(float64(total)*100/float64(100+rate)))
+}
+
+// priceLines 依餐點上的單價計算每個主餐的金額及小計，餐點需先經過 applyCatalog
+func priceLines(meals []OrderMeal) ([]QuoteLine, int) {
+    lines := make([]QuoteLine, 0, len(meals))
+    subtotal := 0
+    for _, meal := range meals {
+        if meal.MainMeal.Delete {
+            continue
+        }
+        optionsPrice := 0
+        for _, sideMeal := range meal.SideMeals {
+            if sideMeal.Delete {
+                continue
+            }
+            optionsPrice += int(math.Round(float64(sideMeal.UnitPrice) * sideMeal.Quantity))
+        }
+        line := QuoteLine{
+            ProductID:    meal.MainMeal.ProductID,
+            Name:         meal.MainMeal.Name,
+            Quantity:     meal.MainMeal.Quantity,
+            UnitPrice:    meal.MainMeal.UnitPrice,
+            OptionsPrice: optionsPrice,
+            LineTotal:    meal.MainMeal.Quantity * (meal.MainMeal.UnitPrice + optionsPrice),
+        }
+        subtotal += line.LineTotal
+        lines = append(lines, line)
+    }
+    return lines, subtotal
+}
+
+// defaultDeliveryFee 不在任何外送區域時的運費，從環境變數 DEFAULT_DELIVERY_FEE 讀取
+func defaultDeliveryFee() int {
+    fee, err := strconv.Atoi(os.Getenv("DEFAULT_DELIVERY_FEE"))
+    if err != nil || fee < 0 {
+        return 0
+    }
+    return fee
+}
+
+// deliveryFee 依外送區域計算運費，折扣後金額達到免運門檻時為 0
+func deliveryFee(q queryer, zoneID, amount int) (int, error) {
+    if zoneID == 0 {
+        return defaultDeliveryFee(), nil
+    }
+    var fee, freeOver int
+    err := q.QueryRow("SELECT DeliveryFee, FreeDeliveryOver FROM DeliveryZones WHERE ID = ?", zoneID).Scan(&fee, &freeOver)
+    if err == sql.ErrNoRows {
+        return defaultDeliveryFee(), nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    if freeOver > 0 && amount >= freeOver {
+        return 0, nil
+    }
+    return fee, nil
+}
+
+// discountAmountFor 計算折扣碼折抵的金額。strict 時折扣碼需啟用、在外送日期的有效期間內且達到最低金額，
+// 否則回傳 errInvalidDiscount；修改已成立訂單的餐點時不檢查期間，未達最低金額則不折抵
+func discountAmountFor(q queryer, code, deliveryDate string, subtotal int, strict bool) (int, error) {
+    var discount DiscountCode
+    var startsOn, endsOn sql.NullString
+    err := q.QueryRow(`SELECT kind, value, min_subtotal, DATE_FORMAT(starts_on, '%Y-%m-%d'), DATE_FORMAT(ends_on, '%Y-%m-%d'), active
+        FROM discount_codes WHERE code = ?`, code).Scan(&discount.Kind, &discount.Value, &discount.MinSubtotal, &startsOn, &endsOn, &discount.Active)
+    if err == sql.ErrNoRows {
+        if strict {
+            return 0, errInvalidDiscount
+        }
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    if strict {
+        if !discount.Active || (startsOn.Valid && deliveryDate < startsOn.String) || (endsOn.Valid && deliveryDate > endsOn.String) {
+            return 0, errInvalidDiscount
+        }
+        if subtotal < discount.MinSubtotal {
+            return 0, errInvalidDiscount
+        }
+    } else if subtotal < discount.MinSubtotal {
+        return 0, nil
+    }
+
+    amount := discount.Value
+    if discount.Kind == discountPercent {
+        amount = int(math.Round(float64(subtotal) * float64(discount.Value) / 100))
+    }
+    if amount > subtotal {
+        amount = subtotal
+    }
+    return amount, nil
+}
+
+// priceOrder 計算餐點的小計、折扣、運費、稅額及總額
+func priceOrder(q queryer, meals []OrderMeal, zoneID int, discountCode, deliveryDate string, strict bool) (*OrderQuote, error) {
+    lines, subtotal := priceLines(meals)
+    quote := &OrderQuote{Lines: lines, Subtotal: subtotal, DiscountCode: discountCode}
+
+    if discountCode != "" {
+        discount, err := discountAmountFor(q, discountCode, deliveryDate, subtotal, strict)
+        if err != nil {
+            return nil, err
+        }
+        quote.Discount = discount
+    }
+
+    fee, err := deliveryFee(q, zoneID, subtotal-quote.Discount)
+    if err != nil {
+        return nil, err
+    }
+    quote.DeliveryFee = fee
+    quote.Total = subtotal - quote.Discount + fee
+    quote.Tax = includedTax(quote.Total)
+    return quote, nil
+}
+
+// QuoteNewOrder 依商品目錄計算新訂單的金額，不會建立訂單，餐點會帶入名稱及單價
+func QuoteNewOrder(req NewOrderRequest) (*OrderQuote, error) {
+    if err := applyCatalog(req.OrderMeals, true); err != nil {
+        return nil, err
+    }
+    zoneID, err := ResolveZoneID(req.LocationID, req.ShippingCityID, req.ShippingRoad)
+    if err != nil {
+        return nil, err
+    }
+    return priceOrder(db, req.OrderMeals, zoneID, req.DiscountCode, req.DeliveryDate, true)
+}
+
+// repriceOrder 修改餐點後在同一個交易中依訂單現有的餐點、區域及折扣碼重新計算金額
+func repriceOrder(tx *sql.Tx, orderID int) (*OrderQuote, error) {
+    var zoneID int
+    var discountCode, deliveryDate string
+    err := tx.QueryRow("SELECT zone_id, discount_code, DATE_FORMAT(delivery_date, '%Y-%m-%d') FROM orders WHERE id = ?", orderID).Scan(&zoneID, &discountCode, &deliveryDate)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := tx.Query(`SELECT p.id, p.product_id, p.name, p.quantity, p.unit_price, o.quantity, o.unit_price
+        FROM order_products p LEFT JOIN order_product_options o ON o.order_product_id = p.id
+        WHERE p.order_id = ? ORDER BY p.sort_order, p.id`, orderID)
+    if err != nil {
+        return nil, err
+    }
+    var meals []OrderMeal
+    for rows.Next() {
+        var product OrderProduct
+        var optionQuantity sql.NullFloat64
+        var optionPrice sql.NullInt64
+        if err := rows.Scan(&product.ID, &product.ProductID, &product.Name, &product.Quantity, &product.UnitPrice, &optionQuantity, &optionPrice); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        if len(meals) == 0 || meals[len(meals)-1].MainMeal.ID != product.ID {
+            meals = append(meals, OrderMeal{MainMeal: product})
+        }
+        if optionQuantity.Valid {
+            meal := &meals[len(meals)-1]
+            meal.SideMeals = append(meal.SideMeals, OrderProductOption{Quantity: optionQuantity.Float64, UnitPrice: int(optionPrice.Int64)})
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    quote, err := priceOrder(tx, meals, zoneID, discountCode, deliveryDate, false)
+    if err != nil {
+        return nil, err
+    }
+    if err := saveOrderTotals(tx, int64(orderID), quote); err != nil {
+        return nil, err
+    }
+    return quote, nil
+}
+
+// saveOrderTotals 寫入訂單的金額
+func saveOrderTotals(q execer, orderID int64, quote *OrderQuote) error {
+    _, err := q.Exec("UPDATE orders SET subtotal = ?, discount = ?, delivery_fee = ?, tax = ?, total = ?, discount_code = ? WHERE id = ?",
+        quote.Subtotal, quote.Discount, quote.DeliveryFee, quote.Tax, quote.Total, quote.DiscountCode, orderID)
+    return err
+}
+
+// FetchOrderTotal 取得訂單的總額及狀態，付款金額一律以此為準
+func FetchOrderTotal(orderCode string) (int, string, error) {
+    var total int
+    var status string
+    err := db.QueryRow("SELECT total, status_code FROM orders WHERE code = ?", orderCode).Scan(&total, &status)
+    if err == sql.ErrNoRows {
+        return 0, "", errOrderNotFound
+    }
+    return total, status, err
+}
+
+// QuoteOrder 試算訂單金額，使用與建立訂單相同的計算，不會建立訂單
+func QuoteOrder(c *gin.Context) {
+    var req NewOrderRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if req.LocationID == 0 {
+        req.LocationID = defaultLocationID
+    }
+    for _, meal := range req.OrderMeals {
+        if meal.MainMeal.Quantity <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "主餐數量必須大於 0"})
+            return
+        }
+    }
+
+    quote, err := QuoteNewOrder(req)
+    if errors.Is(err, errInvalidMeal) || err == errInvalidDiscount {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Printf("QuoteNewOrder error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法計算訂單金額"})
+        return
+    }
+
+    c.JSON(http.StatusOK, quote)
+}
+
+// GetDiscountCodes 取得所有折扣碼
+func GetDiscountCodes(c *gin.Context) {
+    rows, err := db.Query(`SELECT code, kind, value, min_subtotal, DATE_FORMAT(starts_on, '%Y-%m-%d'), DATE_FORMAT(ends_on, '%Y-%m-%d'), active
+        FROM discount_codes ORDER BY code`)
+    if err != nil {
+        log.Printf("GetDiscountCodes error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取折扣碼"})
+        return
+    }
+    defer rows.Close()
+
+    codes := []DiscountCode{}
+    for rows.Next() {
+        var code DiscountCode
+        if err := rows.Scan(&code.Code, &code.Kind, &code.Value, &code.MinSubtotal, &code.StartsOn, &code.EndsOn, &code.Active); err != nil {
+            log.Printf("GetDiscountCodes error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取折扣碼"})
+            return
+        }
+        codes = append(codes, code)
+    }
+
+    c.JSON(http.StatusOK, codes)
+}
+
+// SaveDiscountCode 新增或修改折扣碼
+func SaveDiscountCode(c *gin.Context) {
+    code := DiscountCode{Active: true}
+    if err := c.ShouldBindJSON(&code); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    code.Code = strings.TrimSpace(c.Param("code"))
+    if code.Code == "" || len(code.Code) > 50 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的折扣碼"})
+        return
+    }
+    switch {
+    case code.Kind != discountPercent && code.Kind != discountAmount:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "折扣類型只能是 percent 或 amount"})
+        return
+    case code.Value <= 0 || (code.Kind == discountPercent && code.Value > 100):
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的折扣數值"})
+        return
+    case code.MinSubtotal < 0:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "最低金額不可小於 0"})
+        return
+    }
+    if code.StartsOn != nil && code.EndsOn != nil {
+        if _, _, err := parseDateRange(*code.StartsOn, *code.EndsOn); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+
+    _, err := db.Exec(`INSERT INTO discount_codes (code, kind, value, min_subtotal, starts_on, ends_on, active) VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE kind = VALUES(kind), value = VALUES(value), min_subtotal = VALUES(min_subtotal),
+            starts_on = VALUES(starts_on), ends_on = VALUES(ends_on), active = VALUES(active)`,
+        code.Code, code.Kind, code.Value, code.MinSubtotal, code.StartsOn, code.EndsOn, code.Active)
+    if err != nil {
+        log.Printf("SaveDiscountCode error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法儲存折扣碼"})
+        return
+    }
+
+    c.JSON(http.StatusOK, code)
+}
+
+// DeleteDiscountCode 刪除折扣碼，已使用的訂單保留原本的折扣金額，之後修改餐點時不再折抵
+func DeleteDiscountCode(c *gin.Context) {
+    res, err := db.Exec("DELETE FROM discount_codes WHERE code = ?", c.Param("code"))
+    if err != nil {
+        log.Printf("DeleteDiscountCode error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法刪除折扣碼"})
+        return
+    }
+    if affected, _ := res.RowsAffected(); affected == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個折扣碼"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "折扣碼已刪除"})
+}
@@ -34,10 +34,10 @@ func FetchRoadsByCityID(cityID int) (Roads, error) {
 }
 
 
-// FetchTimeSlotLimits 從數據庫中獲取所有時段限制
-func FetchTimeSlotLimits() ([]TimeSlotLimit, error) {
+// FetchTimeSlotLimits 從數據庫中獲取據點的所有時段限制
+func FetchTimeSlotLimits(locationID int) ([]TimeSlotLimit, error) {
     var limits []TimeSlotLimit
-    rows, err := db.Query("SELECT TimeSlot, LimitCount FROM TimeSlotLimits")
+    rows, err := db.Query("SELECT LocationID, TimeSlot, LimitCount FROM TimeSlotLimits WHERE LocationID = ?", locationID)
     if err != nil {
         return nil, err
     }
@@ -45,7 +45,7 @@ func FetchTimeSlotLimits() ([]TimeSlotLimit, error) {
 
     for rows.Next() {
         var limit TimeSlotLimit
-        if err := rows.Scan(&limit.TimeSlot, &limit.LimitCount); err != nil {
+        if err := rows.Scan(&limit.LocationID, &limit.TimeSlot, &limit.LimitCount); err != nil {
             return nil, err
         }
         limits = append(limits, limit)
@@ -54,11 +54,11 @@ func FetchTimeSlotLimits() ([]TimeSlotLimit, error) {
     return limits, nil
 }
 
-// FetchSpecificDateLimits 從數據庫中獲取特定日期的時段限制
-func FetchSpecificDateLimits() (map[string]map[string]map[string]int, error) {
+// FetchSpecificDateLimits 從數據庫中獲取據點特定日期的時段限制
+func FetchSpecificDateLimits(locationID int) (map[string]map[string]map[string]int, error) {
     today := time.Now().Format("2006-01-02")
 
-    rows, err := db.Query("SELECT Date, TimeSlot, LimitCount FROM DateLimits WHERE Date >= ? ORDER BY Date, TimeSlot", today)
+    rows, err := db.Query("SELECT Date, TimeSlot, LimitCount FROM DateLimits WHERE LocationID = ? AND Date >= ? ORDER BY Date, TimeSlot", locationID, today)
   
     // rows, err := db.Query("SELECT Date, TimeSlot, LimitCount FROM DateLimits ORDER BY Date, TimeSlot")
     if err != nil {
@@ -93,16 +93,9 @@ func FetchSpecificDateLimits() (map[string]map[string]map[string]int, error) {
     return monthlyLimits, nil
 }
 
-func InsertTimeSlotLimits(limits TimeSlotLimits) error {
+func InsertTimeSlotLimits(tx *sql.Tx, limits TimeSlotLimits) error {
     for _, limit := range limits {
-        stmt, err := db.Prepare("INSERT INTO TimeSlotLimits (TimeSlot, LimitCount) VALUES (?, ?)")
-        if err != nil {
-            return err 
-        }
-
-        _, err = stmt.Exec(limit.TimeSlot, limit.LimitCount)
-        stmt.Close() 
-
+        _, err := tx.Exec("INSERT INTO TimeSlotLimits (LocationID, TimeSlot, LimitCount) VALUES (?, ?, ?)", limit.LocationID, limit.TimeSlot, limit.LimitCount)
         if err != nil {
             return err 
         }
@@ -111,15 +104,9 @@ func InsertTimeSlotLimits(limits TimeSlotLimits) error {
 }
 
 
-func UpdateExistingTimeSlotLimits(limits map[string]int) error {
+func UpdateExistingTimeSlotLimits(tx *sql.Tx, locationID int, limits map[string]int) error {
     for timeSlot, limitCount := range limits {
-        stmt, err := db.Prepare("UPDATE TimeSlotLimits SET LimitCount = ? WHERE TimeSlot = ?")
-        if err != nil {
-            return err
-        }
-        defer stmt.Close()
-
-        _, err = stmt.Exec(limitCount, timeSlot)
+        _, err := tx.Exec("UPDATE TimeSlotLimits SET LimitCount = ? WHERE LocationID = ? AND TimeSlot = ?", limitCount, locationID, timeSlot)
         if err != nil {
             return err
         }
@@ -127,47 +114,16 @@ func UpdateExistingTimeSlotLimits(limits map[string]int) error {
     return nil
 }
 
-//增加設定日期
-func insertDateIfNeeded(date string) error {
-    // 檢查日期是否已存在
-    var exists bool
-    err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM Dates WHERE Date = ?)", date).Scan(&exists)
-    if err != nil {
-        return err
-    }
-
-    // 如果日期不存在，則插入
-    if !exists {
-        stmt, err := db.Prepare("INSERT INTO Dates (Date) VALUES (?)")
-        if err != nil {
-            return err
-        }
-        defer stmt.Close()
-
-        _, err = stmt.Exec(date)
-        if err != nil {
-            return err
-        }
-    }
-
-    return nil
-}
-func InsertSpecificDateLimit(dateLimit SpecificDateLimit) error {
+func InsertSpecificDateLimit(tx *sql.Tx, dateLimit SpecificDateLimit) error {
     // 首先檢查並可能插入日期到Dates表
-    if err := insertDateIfNeeded(dateLimit.Date); err != nil {
+    if err := insertDateIfNeededTx(tx, dateLimit.Date); err != nil {
         return err 
     }
 
      // 插入或更新 DateLimits 表
      for timeSlot, limit := range dateLimit.TimeLimits {
-        stmt, err := db.Prepare("INSERT INTO DateLimits (Date, TimeSlot, LimitCount) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount)")
-        if err != nil {
-            return err 
-        }
-
-        _, err = stmt.Exec(dateLimit.Date, timeSlot, limit)
-        stmt.Close() // 立即關閉語句
-
+        _, err := tx.Exec("INSERT INTO DateLimits (LocationID, Date, TimeSlot, LimitCount, Origin) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount), Origin = VALUES(Origin)",
+            dateLimit.LocationID, dateLimit.Date, timeSlot, limit, originManual)
         if err != nil {
             return err 
         }
@@ -179,13 +135,13 @@ func InsertSpecificDateLimit(dateLimit SpecificDateLimit) error {
 
 func UpdateExistingSpecificDateLimit(dateLimit SpecificDateLimit) error {
     for timeSlot, limit := range dateLimit.TimeLimits {
-        stmt, err := db.Prepare("UPDATE DateLimits SET LimitCount = ? WHERE Date = ? AND TimeSlot = ?")
+        stmt, err := db.Prepare("UPDATE DateLimits SET LimitCount = ?, Origin = 'manual' WHERE LocationID = ? AND Date = ? AND TimeSlot = ?")
         if err != nil {
             return err
         }
         defer stmt.Close()
 
-        _, err = stmt.Exec(limit, dateLimit.Date, timeSlot)
+        _, err = stmt.Exec(limit, dateLimit.LocationID, dateLimit.Date, timeSlot)
         if err != nil {
             return err
         }
@@ -193,18 +149,13 @@ func UpdateExistingSpecificDateLimit(dateLimit SpecificDateLimit) error {
     return nil
 }
 
-// AutoCreateNextTwoMonthsLimits 自動新增特定時間範圍的限制
-func AutoCreateNextTwoMonthsLimits(period string , cover bool) error {
-    // 取得現有設定日期
-    existingLimits, err := FetchSpecificDateLimits()
+// AutoCreateNextTwoMonthsLimits 依預設模板自動新增據點特定時間範圍的限制，整段範圍在同一個交易中寫入，
+// 任何一個時段失敗時整批取消。覆蓋模式下人工修改過的時段會略過，除非指定 Force。
+func AutoCreateNextTwoMonthsLimits(locationID int, opts AutoCreateOptions) (*AutoCreateReport, error) {
+    // 取得該據點的預設
+    initialLimits, err := FetchTimeSlotLimits(locationID)
     if err != nil {
-        return err
-    }
-
-    // 取得預設
-    initialLimits, err := FetchTimeSlotLimits()
-    if err != nil {
-        return err
+        return nil, err
     }
 
     // 轉格式
@@ -214,46 +165,164 @@ func AutoCreateNextTwoMonthsLimits(period string , cover bool) error {
     }
 
     // 時間範圍
+    startDate, endDate, err := autoCreateRange(opts)
+    if err != nil {
+        return nil, err
+    }
+
+    // 以預測的建議上限取代模板數量
+    var model *forecastModel
+    if opts.Forecast {
+        if model, err = buildForecastModel(locationID, time.Now(), defaultForecastWeeks); err != nil {
+            return nil, err
+        }
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    // 鎖定並取得現有設定及來源
+    existingLimits, err := lockDateLimitRows(tx, locationID, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), "FOR UPDATE")
+    if err != nil {
+        return nil, err
+    }
+
+    report := &AutoCreateReport{
+        StartDate:   startDate.Format("2006-01-02"),
+        EndDate:     endDate.AddDate(0, 0, -1).Format("2006-01-02"),
+        DryRun:      opts.DryRun,
+        Created:     []LimitChange{},
+        Overwritten: []LimitChange{},
+        Skipped:     []LimitChange{},
+    }
+    for d := startDate; d.Before(endDate); d = d.AddDate(0, 0, 1) {
+        dateStr := d.Format("2006-01-02")
+        existing := existingLimits[dateStr]
+
+        // 不覆蓋時已有設定的日期直接跳過
+        if !opts.Cover && len(existing) > 0 {
+            continue
+        }
+
+        for timeSlot, limitCount := range initialTimeLimits {
+            if model != nil {
+                _, limitCount, _ = model.suggest(d, timeSlot)
+            }
+            change := LimitChange{Date: dateStr, TimeSlot: timeSlot, New: limitCount}
+            row, exists := existing[timeSlot]
+            switch {
+            case !exists:
+                change.Created = true
+            case row.Origin == originManual && !opts.Force:
+                change.Old = row.LimitCount
+                report.Skipped = append(report.Skipped, change)
+                continue
+            case row.LimitCount == limitCount && row.Origin == opts.Origin:
+                continue
+            default:
+                change.Old = row.LimitCount
+            }
+
+            if !opts.DryRun {
+                if err := upsertDateLimit(tx, locationID, change, opts.Origin, opts.Actor); err != nil {
+                    return nil, err
+                }
+            }
+            if change.Created {
+                report.Created = append(report.Created, change)
+            } else {
+                report.Overwritten = append(report.Overwritten, change)
+            }
+        }
+    }
+
+    if opts.DryRun {
+        return report, nil
+    }
+    return report, tx.Commit()
+}
+
+// autoCreateRange 依指定的開始、結束日期或 Period 算出自動新增的範圍，結束時間不含
+func autoCreateRange(opts AutoCreateOptions) (time.Time, time.Time, error) {
+    if opts.StartDate != "" || opts.EndDate != "" {
+        start, end, err := parseDateRange(opts.StartDate, opts.EndDate)
+        if err != nil {
+            return time.Time{}, time.Time{}, err
+        }
+        return start, end.AddDate(0, 0, 1), nil
+    }
+
     startDate := time.Now()
-    var endDate time.Time
-    switch period {
+    switch opts.Period {
     case "oneWeek":
-        endDate = startDate.AddDate(0, 0, 7)
+        return startDate, startDate.AddDate(0, 0, 7), nil
     case "twoWeeks":
-        endDate = startDate.AddDate(0, 0, 14)
+        return startDate, startDate.AddDate(0, 0, 14), nil
     case "oneMonth":
-        endDate = startDate.AddDate(0, 1, 0)
+        return startDate, startDate.AddDate(0, 1, 0), nil
     case "twoMonths":
-        endDate = startDate.AddDate(0, 2, 0)
-    default:
-        return fmt.Errorf("不支援的時間範圍: %s", period)
+        return startDate, startDate.AddDate(0, 2, 0), nil
     }
+    return time.Time{}, time.Time{}, fmt.Errorf("不支援的時間範圍: %s", opts.Period)
+}
 
-  // 日期循環
- for d := startDate; d.Before(endDate); d = d.AddDate(0, 0, 1) {
-    dateStr := d.Format("2006-01-02")
-    yearMonth := d.Format("2006-01") 
-
- // 檢查日期是否已存在
-if !cover{
-    if monthLimits, monthExists := existingLimits[yearMonth]; monthExists {
-        if _, dateExists := monthLimits[dateStr]; dateExists {
-            continue // 如果该日期已存在，则跳过
+// upsertDateLimit 在呼叫端的交易中寫入單一時段上限及來源並記錄變更
+func upsertDateLimit(tx *sql.Tx, locationID int, change LimitChange, origin string, actor Actor) error {
+    if change.Created {
+        if err := insertDateIfNeededTx(tx, change.Date); err != nil {
+            return err
         }
     }
-}
-      // 沒有設定的插入預設
-    dateLimit := SpecificDateLimit{
-        Date:       dateStr,
-        TimeLimits: initialTimeLimits,
+    _, err := tx.Exec("INSERT INTO DateLimits (LocationID, Date, TimeSlot, LimitCount, Origin) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount), Origin = VALUES(Origin)",
+        locationID, change.Date, change.TimeSlot, change.New, origin)
+    if err != nil {
+        return err
     }
 
-    if err := InsertSpecificDateLimit(dateLimit); err != nil {
-        return err
+    var old *int
+    if !change.Created {
+        old = intPtr(change.Old)
     }
+    return auditDateLimit(tx, actor, locationID, change.Date, change.TimeSlot, old, change.New)
+}
+
+// dateLimitRow 表示 DateLimits 的一筆資料及其來源
+type dateLimitRow struct {
+    LimitCount int
+    Origin     string
 }
 
-return nil
+// fetchDateLimitRows 取得據點日期範圍內的時段上限及來源，以日期、時段為 key
+func fetchDateLimitRows(locationID int, startDate, endDate string) (map[string]map[string]dateLimitRow, error) {
+    return lockDateLimitRows(db, locationID, startDate, endDate, "")
+}
+
+// lockDateLimitRows 同 fetchDateLimitRows，lock 可加上 FOR UPDATE 在交易中鎖定
+func lockDateLimitRows(q rowsQueryer, locationID int, startDate, endDate, lock string) (map[string]map[string]dateLimitRow, error) {
+    rows, err := q.Query("SELECT Date, TimeSlot, LimitCount, Origin FROM DateLimits WHERE LocationID = ? AND Date BETWEEN ? AND ? "+lock,
+        locationID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    limits := make(map[string]map[string]dateLimitRow)
+    for rows.Next() {
+        var date, timeSlot string
+        var row dateLimitRow
+        if err := rows.Scan(&date, &timeSlot, &row.LimitCount, &row.Origin); err != nil {
+            return nil, err
+        }
+        if _, ok := limits[date]; !ok {
+            limits[date] = make(map[string]dateLimitRow)
+        }
+        limits[date][timeSlot] = row
+    }
+
+    return limits, rows.Err()
 }
 
 // FetchUserIDByNameAndMobile 從數據庫中根據手機號碼和姓名獲取使用者 ID
@@ -286,7 +355,7 @@ func FetchUserIDByNameAndMobile(name, mobile string) (string, error) {
 
 
 //FetchDelivery 根據訂單編號查詢外送員
-func FetchDelivery(orderCode int) (*orderDelivery, error) {
+func FetchDelivery(orderCode string) (*orderDelivery, error) {
     var delivery orderDelivery
 
     err := db.QueryRow("SELECT id, order_code, delivery_id, name, phone, cartype, fee FROM order_delivery WHERE order_code = ?", orderCode).Scan(
@@ -312,38 +381,15 @@ func FetchDelivery(orderCode int) (*orderDelivery, error) {
 
 // FetchOrderByCriteria 根據指定條件查詢訂單
 func FetchOrderByCriteria(criteria map[string]string) ([]Order, error) {
-    query := "SELECT id AS orderID,code, personal_name, DATE_FORMAT(delivery_date, '%Y-%m-%d') as delivery_date, shipping_state_id, shipping_city_id, shipping_road, shipping_address1, status_code, delivery_time_range, mobile,shipping_status FROM orders WHERE "
-    var args []interface{}
-
+    // 欄位名稱只來自白名單，不直接使用 map 的 key 組 SQL
+    query := &orderQuery{}
     for key, value := range criteria {
-        query += fmt.Sprintf("%s = ? AND ", key)
-        args = append(args, value)
-    }
-
-    // 移除最後的 "AND "
-    query = query[:len(query)-5]
-    log.Printf("SQL Query: %s", query)  // 添加日誌輸出
-    rows, err := db.Query(query, args...)
-    if err != nil {
-        log.Printf("Query error: %v", err)  // 添加錯誤日誌
-        return nil, err
-    }
-    defer rows.Close()
-
-    var orders []Order // Order 是你的訂單結構
-
-    for rows.Next() {
-        var order Order
-        // 可以根據Order結構調整scan
-        if err := rows.Scan(&order.OrderID,&order.Code, &order.PersonalName, &order.DeliveryDate, &order.ShippingStateID, &order.ShippingCityID, &order.ShippingRoad, &order.ShippingAddress1, &order.StatusCode, &order.DeliveryTimeRange, &order.Mobile,&order.ShippingStatus); err != nil {
-            log.Printf("Scan error: %v", err)  // 添加錯誤日誌
+        if err := query.eq(key, value); err != nil {
             return nil, err
         }
-        orders = append(orders, order)
-        log.Printf("Found order: %+v", order) // 日誌輸出訂單詳情
     }
 
-    return orders, nil
+    return fetchOrders("SELECT "+orderColumns+" FROM orders"+query.whereClause(), query.args...)
 }
 
 
@@ -445,7 +491,7 @@ func FetchAllImages() ([]Image, error) {
 // FetchOrderProducts 根據訂單 ID 獲取訂單餐點
 func FetchOrderProducts(orderID int) ([]OrderProduct, error) {
     var products []OrderProduct
-    rows, err := db.Query("SELECT id, order_id, product_id, name, quantity FROM order_products WHERE order_id = ?", orderID)
+    rows, err := db.Query("SELECT id, order_id, product_id, name, quantity, unit_price FROM order_products WHERE order_id = ? ORDER BY sort_order, id", orderID)
     if err != nil {
         return nil, err
     }
@@ -453,7 +499,7 @@ func FetchOrderProducts(orderID int) ([]OrderProduct, error) {
 
     for rows.Next() {
         var product OrderProduct
-        if err := rows.Scan(&product.ID, &product.OrderID, &product.ProductID, &product.Name, &product.Quantity); err != nil {
+        if err := rows.Scan(&product.ID, &product.OrderID, &product.ProductID, &product.Name, &product.Quantity, &product.UnitPrice); err != nil {
             return nil, err
         }
         products = append(products, product)
@@ -465,7 +511,7 @@ func FetchOrderProducts(orderID int) ([]OrderProduct, error) {
 // FetchOrderProductOptions 根據 OrderProduct ID 獲取附餐選項
 func FetchOrderProductOptions(orderProductID int) ([]OrderProductOption, error) {
     var options []OrderProductOption
-    rows, err := db.Query("SELECT id, order_product_id, product_id, name, value, quantity FROM order_product_options WHERE order_product_id = ?", orderProductID)
+    rows, err := db.Query("SELECT id, order_product_id, product_id, name, value, quantity, unit_price FROM order_product_options WHERE order_product_id = ? ORDER BY id", orderProductID)
     if err != nil {
         log.Printf("Error FetchOrderProductOptions: %v", err)
         return nil, err
@@ -474,7 +520,7 @@ func FetchOrderProductOptions(orderProductID int) ([]OrderProductOption, error)
 
     for rows.Next() {
         var option OrderProductOption
-        if err := rows.Scan(&option.ID, &option.OrderProductID, &option.ProductID, &option.Name, &option.Value, &option.Quantity); err != nil {
+        if err := rows.Scan(&option.ID, &option.OrderProductID, &option.ProductID, &option.Name, &option.Value, &option.Quantity, &option.UnitPrice); err != nil {
             return nil, err
         }
         options = append(options, option)
@@ -484,28 +530,6 @@ func FetchOrderProductOptions(orderProductID int) ([]OrderProductOption, error)
 }
 
 
-// UpdateOrderStatus 用訂單編號更改訂單狀態
-func UpdateOrderStatus(orderCode string) error {
-    stmt, err := db.Prepare("UPDATE orders SET status_code = 'Void' WHERE code = ?")
-    if err != nil {
-        log.Printf("Prepare update error: %v", err)
-        return err
-    }
-    defer stmt.Close()
-
-    _, err = stmt.Exec(orderCode)
-    if err != nil {
-        log.Printf("Execute update error: %v", err)
-        return err
-    }
-
-    log.Printf("Order status updated to 'Void' for order code: %s", orderCode)
-    return nil
-}
-
-
-
-
 // FetchTest2ByName 通过名称从 test2 表中检索条目。
 func FetchTest2ByName(typeStr, name string) (*Test2, error) {
     var t Test2
@@ -11,17 +11,47 @@ func LoadRoutes(r *gin.Engine) {
 
 	r.GET("/get-timeslot", GetTimeSlotLimits)
 	r.GET("/get-road", GetRoadsByCityID)
+	r.GET("/delivery-zones", GetDeliveryZones)
+	r.POST("/delivery-zones", requireAdmin, CreateDeliveryZone)
+	r.GET("/zone-limits", GetZoneLimits)
+	r.PUT("/zone-limits", requireAdmin, SetZoneLimits)
+	r.DELETE("/zone-limits", requireAdmin, DeleteZoneLimits)
 	r.GET("/get-special", GetSpecificDateLimits)
-	r.POST("/add-timeslot",CreateTimeSlotLimit)
-	r.POST("/add-special",CreateSpecificDateLimit)
-	r.PUT("/update-timeslot",UpdateTimeSlotLimit)
-	r.PUT("/add-order",UpdateSpecificDateLimit)
-	r.POST("/auto-add", TriggerAutoCreateLimits)
-	r.POST("/start-scheduler", StartSchedulerHandler)
-	r.POST("/stop-scheduler", StopSchedulerHandler)
+	r.GET("/availability", GetAvailability)
+	r.GET("/cutoffs", GetCutoffs)
+	r.PUT("/cutoffs", requireAdmin, SetCutoffs)
+	r.DELETE("/cutoffs", requireAdmin, DeleteCutoff)
+	r.POST("/holds", CreateHold)
+	r.DELETE("/holds/:token", ReleaseHoldHandler)
+	r.GET("/waitlist", requireAdmin, GetWaitlist)
+	r.POST("/waitlist", JoinWaitlist)
+	r.DELETE("/waitlist/:id", CancelWaitlist)
+	r.POST("/add-timeslot", requireAdmin, CreateTimeSlotLimit)
+	r.POST("/add-special", requireAdmin, CreateSpecificDateLimit)
+	r.PUT("/update-timeslot", requireAdmin, UpdateTimeSlotLimit)
+	r.PUT("/add-order", requireAdmin, UpdateSpecificDateLimit)
+	r.POST("/bulk-special", requireAdmin, BulkUpdateDateLimits)
+	r.GET("/capacity-audit", requireAdmin, GetCapacityAudit)
+	r.POST("/auto-add", requireAdmin, TriggerAutoCreateLimits)
+	r.GET("/capacity-forecast", GetCapacityForecast)
+	r.GET("/capacity-archive", GetDateLimitArchive)
+	r.GET("/capacity-calendar.ics", ExportCapacityICS)
+	r.GET("/capacity-calendar.csv", ExportCapacityCSV)
+	r.GET("/kitchen-report", requireAdmin, GetProductionReport) // 備餐報表
+	r.GET("/kitchen-report.csv", requireAdmin, ExportProductionCSV)
+	r.GET("/kitchen-report.html", requireAdmin, ExportProductionHTML)
+	r.GET("/delivery-manifest", requireAdmin, GetDeliveryManifest) // 派送單
+	r.GET("/delivery-manifest.csv", requireAdmin, ExportDeliveryManifestCSV)
+	r.GET("/delivery-manifest.html", requireAdmin, ExportDeliveryManifestHTML)
+	r.POST("/start-scheduler", requireAdmin, StartSchedulerHandler)
+	r.POST("/stop-scheduler", requireAdmin, StopSchedulerHandler)
 	r.GET("/scheduler-status", GetSchedulerStatusHandler)
+	r.GET("/jobs", GetScheduledJobs)
+	r.PUT("/jobs/:name", requireAdmin, UpdateJobHandler)
+	r.POST("/jobs/:name/:action", requireAdmin, JobActionHandler)
 	r.GET("/get-member", GetUserByID)
 	r.GET("/order", GetOrderByCriteria)
+	r.GET("/admin/orders", requireAdmin, SearchOrders)
 	r.GET("/get-image/:id", GetImage)     // 取得圖片
 	r.POST("/upload-image", UploadImage)
     r.PUT("/replace-image/:image_id", ReplaceImage)
@@ -29,14 +59,32 @@ func LoadRoutes(r *gin.Engine) {
 	r.GET("/order/:order_id/products", GetOrderProducts) //主餐
     r.GET("/order-product/:order_product_id/options", GetOrderProductOptions) //副餐
 	r.GET("/order/:order_id", GetCompleteOrderMeal) //全部
-    r.POST("/order", CreateNewOrder)  // 創建新訂單餐點
-	r.PUT("/order-update", UpdateOrderMeal)	  // 更新現有訂單餐點
+    r.POST("/order", idempotent("order"), CreateNewOrder)  // 創建新訂單餐點
+	r.POST("/order-quote", QuoteOrder) // 試算訂單金額
+	r.PUT("/order/:order_id/meals", UpdateOrderMeal)   // 以送出的餐點取代訂單餐點
+	r.PATCH("/order/:order_id/meals", UpdateOrderMeal) // 只修改送出的餐點
 	r.GET("/delivery/:order_code", GetDeliveryByOrderCode)
+	r.GET("/products", GetProducts)       // 商品目錄
+	r.GET("/products/:id", GetProduct)
+	r.POST("/products", requireAdmin, CreateProduct)
+	r.PUT("/products/:id", requireAdmin, UpdateProduct)
+	r.DELETE("/products/:id", requireAdmin, DeleteProduct)
+	r.GET("/option-groups", GetOptionGroups) // 選項群組
+	r.POST("/option-groups", requireAdmin, CreateOptionGroup)
+	r.PUT("/option-groups/:id", requireAdmin, UpdateOptionGroup)
+	r.DELETE("/option-groups/:id", requireAdmin, DeleteOptionGroup)
+	r.POST("/option-groups/:id/options", requireAdmin, CreateProductOption)
+	r.PUT("/product-options/:id", requireAdmin, UpdateProductOption)
+	r.DELETE("/product-options/:id", requireAdmin, DeleteProductOption)
+	r.GET("/discount-codes", requireAdmin, GetDiscountCodes) // 折扣碼
+	r.PUT("/discount-codes/:code", requireAdmin, SaveDiscountCode)
+	r.DELETE("/discount-codes/:code", requireAdmin, DeleteDiscountCode)
 
-	r.PUT("/order/:code/void", UpdateOrderStatusHandler)
+	r.POST("/order-status/:code", TransitionOrderStatusHandler)
+	r.GET("/order-status/:code/history", requireAdmin, GetOrderStatusHistory)
 	r.GET("/test2/:type/:name", GetTest2ByName)
 r.PUT("/test2/update", UpdateTest2ByName)
-r.POST("/order/creat", ForwardOrderToHTTPService)
+r.POST("/order/creat", idempotent("pos-order"), ForwardOrderToHTTPService)
 
 	
 }
@@ -0,0 +1,572 @@
+// scheduler.go
+package api
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// 排程工作狀態
+const (
+    jobActive  = "active"
+    jobPaused  = "paused"
+    jobStopped = "stopped"
+)
+
+// JobFunc 排程工作要執行的內容，回傳記錄在執行紀錄中的摘要，ctx 在伺服器關閉時取消
+type JobFunc func(ctx context.Context) (string, error)
+
+// jobDefinition 程式內註冊的排程工作，Cron 與 Status 只在資料庫沒有紀錄時作為預設值
+type jobDefinition struct {
+    Name   string
+    Cron   string
+    Status string
+    Run    JobFunc
+}
+
+// defaultJobs 所有可排程的工作
+func defaultJobs() []jobDefinition {
+    return []jobDefinition{
+        {
+            // 原本 /start-scheduler 啟動的每天 6 點自動新增，預設不啟動
+            Name:   autoCreateJobName,
+            Cron:   "0 6 * * *",
+            Status: jobStopped,
+            Run: func(ctx context.Context) (string, error) {
+                return autoCreateAllLocations(ctx, "oneMonth", false, autoAddUseForecast())
+            },
+        },
+        {
+            Name:   "expire-holds",
+            Cron:   "* * * * *",
+            Status: jobActive,
+            Run: func(ctx context.Context) (string, error) {
+                return sweepExpiredHolds(ctx)
+            },
+        },
+        {
+            // 每天清晨封存超過保留天數的時段上限
+            Name:   "archive-date-limits",
+            Cron:   "30 3 * * *",
+            Status: jobActive,
+            Run: func(ctx context.Context) (string, error) {
+                return archiveDateLimits(ctx)
+            },
+        },
+        {
+            Name:   "purge-idempotency-keys",
+            Cron:   "0 * * * *",
+            Status: jobActive,
+            Run: func(ctx context.Context) (string, error) {
+                return purgeIdempotencyKeys(ctx)
+            },
+        },
+    }
+}
+
+var errJobNotFound = errors.New("沒有這個排程工作")
+
+// scheduledJob 執行中的排程工作
+type scheduledJob struct {
+    def     jobDefinition
+    expr    string
+    cron    *cronSchedule
+    cancel  context.CancelFunc // 不為 nil 表示已排程
+    running bool               // 避免同一工作重疊執行
+}
+
+// Scheduler 依資料庫中的設定執行排程工作，重新啟動後會恢復原本的狀態。
+// 每台伺服器都會排程，但只有持有租約的伺服器實際執行。
+type Scheduler struct {
+    mu     sync.Mutex
+    ctx    context.Context
+    wg     sync.WaitGroup
+    jobs   map[string]*scheduledJob
+    leader bool
+}
+
+var scheduler *Scheduler
+
+// StartScheduler 載入所有排程工作並啟動狀態為 active 的工作，ctx 取消後全部停止
+func StartScheduler(ctx context.Context) error {
+    s := &Scheduler{ctx: ctx, jobs: make(map[string]*scheduledJob)}
+    s.renewLease()
+
+    for _, def := range defaultJobs() {
+        // 第一次啟動時寫入預設設定，之後以資料庫為準
+        _, err := db.Exec("INSERT IGNORE INTO ScheduledJobs (Name, Cron, Status) VALUES (?, ?, ?)", def.Name, def.Cron, def.Status)
+        if err != nil {
+            return err
+        }
+
+        stored, err := FetchScheduledJob(def.Name)
+        if err != nil {
+            return err
+        }
+        schedule, err := parseCron(stored.Cron)
+        if err != nil {
+            return fmt.Errorf("%s: %v", def.Name, err)
+        }
+
+        job := &scheduledJob{def: def, expr: stored.Cron, cron: schedule}
+        s.jobs[def.Name] = job
+        if stored.Status == jobActive {
+            // 停機期間錯過的執行在啟動時補跑一次
+            missed := stored.NextRunAt != nil && stored.NextRunAt.Before(time.Now())
+            s.schedule(job, missed)
+        }
+    }
+
+    s.wg.Add(1)
+    go func() {
+        defer s.wg.Done()
+        s.heartbeat()
+    }()
+
+    scheduler = s
+    log.Printf("排程已啟動 (%s)", instanceID)
+    return nil
+}
+
+// StopScheduler 等待執行中的工作結束並釋放租約，呼叫前須先取消 StartScheduler 的 ctx
+func StopScheduler() {
+    if scheduler == nil {
+        return
+    }
+    scheduler.wg.Wait()
+    if err := releaseLease(schedulerLeaseName); err != nil {
+        log.Printf("releaseLease error: %v", err)
+    }
+    log.Println("排程已停止")
+}
+
+// heartbeat 定期續約，並同步其他伺服器透過 API 修改的排程設定
+func (s *Scheduler) heartbeat() {
+    ticker := time.NewTicker(leaseRenewInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.ctx.Done():
+            return
+        case <-ticker.C:
+            s.renewLease()
+            if err := s.syncJobs(); err != nil {
+                log.Printf("syncJobs error: %v", err)
+            }
+        }
+    }
+}
+
+// renewLease 取得或續約租約，回傳這台伺服器是否為排程主機
+func (s *Scheduler) renewLease() bool {
+    leader, err := acquireLease(schedulerLeaseName)
+    if err != nil {
+        log.Printf("acquireLease error: %v", err)
+        leader = false
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if leader != s.leader {
+        if leader {
+            log.Printf("%s 成為排程主機", instanceID)
+        } else {
+            log.Printf("%s 不再是排程主機", instanceID)
+        }
+    }
+    s.leader = leader
+    return leader
+}
+
+// syncJobs 依資料庫的狀態及 cron 表達式調整本機的排程
+func (s *Scheduler) syncJobs() error {
+    stored, err := FetchScheduledJobs()
+    if err != nil {
+        return err
+    }
+
+    for _, st := range stored {
+        job, ok := s.jobs[st.Name]
+        if !ok {
+            continue
+        }
+
+        s.mu.Lock()
+        changed := job.expr != st.Cron
+        active := job.cancel != nil
+        s.mu.Unlock()
+
+        if changed {
+            schedule, err := parseCron(st.Cron)
+            if err != nil {
+                log.Printf("排程 %s cron 錯誤: %v", st.Name, err)
+                continue
+            }
+            s.mu.Lock()
+            job.expr, job.cron = st.Cron, schedule
+            s.mu.Unlock()
+            if active {
+                s.unschedule(job)
+            }
+        }
+
+        if st.Status == jobActive {
+            s.schedule(job, false)
+        } else if active {
+            s.unschedule(job)
+        }
+    }
+    return nil
+}
+
+// schedule 為工作啟動排程 goroutine，呼叫端不需持有鎖
+func (s *Scheduler) schedule(job *scheduledJob, runNow bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if job.cancel != nil {
+        return
+    }
+
+    ctx, cancel := context.WithCancel(s.ctx)
+    job.cancel = cancel
+    s.wg.Add(1)
+    go func() {
+        defer s.wg.Done()
+        s.loop(ctx, job, runNow)
+    }()
+}
+
+// unschedule 取消工作的排程，不會中斷正在執行的工作
+func (s *Scheduler) unschedule(job *scheduledJob) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if job.cancel != nil {
+        job.cancel()
+        job.cancel = nil
+    }
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob, runNow bool) {
+    if runNow {
+        s.run(ctx, job)
+    }
+
+    for {
+        s.mu.Lock()
+        next := job.cron.Next(time.Now())
+        s.mu.Unlock()
+        if next.IsZero() {
+            log.Printf("排程 %s 找不到下次執行時間", job.def.Name)
+            return
+        }
+        // 已被暫停或停止時不再寫入下次執行時間，下次執行時間只由排程主機寫入
+        if ctx.Err() != nil {
+            return
+        }
+        if s.isLeader() {
+            if err := saveJobNextRun(job.def.Name, &next); err != nil {
+                log.Printf("saveJobNextRun error: %v", err)
+            }
+        }
+
+        timer := time.NewTimer(time.Until(next))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+        s.run(ctx, job)
+    }
+}
+
+func (s *Scheduler) isLeader() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.leader
+}
+
+// run 執行一次工作，不是排程主機或同一工作已在執行時略過
+func (s *Scheduler) run(ctx context.Context, job *scheduledJob) {
+    // 執行前再確認一次租約，避免前一任主機剛失效時兩台同時執行
+    if !s.renewLease() {
+        return
+    }
+
+    s.mu.Lock()
+    if job.running {
+        s.mu.Unlock()
+        log.Printf("排程 %s 仍在執行，略過這次", job.def.Name)
+        return
+    }
+    job.running = true
+    s.mu.Unlock()
+
+    defer func() {
+        s.mu.Lock()
+        job.running = false
+        s.mu.Unlock()
+    }()
+
+    recordJobRun(ctx, job.def)
+    if _, err := db.Exec("UPDATE ScheduledJobs SET LastRunAt = ? WHERE Name = ?", time.Now(), job.def.Name); err != nil {
+        log.Printf("更新排程執行時間失敗: %v", err)
+    }
+}
+
+func (s *Scheduler) job(name string) (*scheduledJob, error) {
+    if s == nil {
+        return nil, errors.New("排程尚未啟動")
+    }
+    job, ok := s.jobs[name]
+    if !ok {
+        return nil, errJobNotFound
+    }
+    return job, nil
+}
+
+// StartJob 啟動 (或恢復暫停的) 工作
+func (s *Scheduler) StartJob(name string) error {
+    job, err := s.job(name)
+    if err != nil {
+        return err
+    }
+    if err := saveJobStatus(name, jobActive); err != nil {
+        return err
+    }
+    s.schedule(job, false)
+    return nil
+}
+
+// PauseJob 暫停工作，保留下次執行時間供查看
+func (s *Scheduler) PauseJob(name string) error {
+    job, err := s.job(name)
+    if err != nil {
+        return err
+    }
+    s.unschedule(job)
+    return saveJobStatus(name, jobPaused)
+}
+
+// StopJob 停止工作並清除下次執行時間
+func (s *Scheduler) StopJob(name string) error {
+    job, err := s.job(name)
+    if err != nil {
+        return err
+    }
+    s.unschedule(job)
+    if err := saveJobStatus(name, jobStopped); err != nil {
+        return err
+    }
+    return saveJobNextRun(name, nil)
+}
+
+// UpdateJobCron 修改工作的 cron 表達式，排程中的工作會以新設定重新排程
+func (s *Scheduler) UpdateJobCron(name, expr string) error {
+    job, err := s.job(name)
+    if err != nil {
+        return err
+    }
+    schedule, err := parseCron(expr)
+    if err != nil {
+        return err
+    }
+    if _, err := db.Exec("UPDATE ScheduledJobs SET Cron = ? WHERE Name = ?", expr, name); err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    job.expr = expr
+    job.cron = schedule
+    active := job.cancel != nil
+    s.mu.Unlock()
+
+    if active {
+        s.unschedule(job)
+        s.schedule(job, false)
+    }
+    return nil
+}
+
+// RunJobNow 立即在背景執行一次工作，不影響原本的排程，只能在排程主機上執行
+func (s *Scheduler) RunJobNow(name string) error {
+    job, err := s.job(name)
+    if err != nil {
+        return err
+    }
+    if !s.renewLease() {
+        return errNotLeader
+    }
+    s.wg.Add(1)
+    go func() {
+        defer s.wg.Done()
+        s.run(s.ctx, job)
+    }()
+    return nil
+}
+
+// IsJobActive 工作是否已排程
+func (s *Scheduler) IsJobActive(name string) bool {
+    job, err := s.job(name)
+    if err != nil {
+        return false
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return job.cancel != nil
+}
+
+// FetchScheduledJobs 取得所有排程工作的設定
+func FetchScheduledJobs() ([]ScheduledJob, error) {
+    rows, err := db.Query("SELECT Name, Cron, Status, NextRunAt, LastRunAt FROM ScheduledJobs ORDER BY Name")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var jobs []ScheduledJob
+    for rows.Next() {
+        job, err := scanScheduledJob(rows)
+        if err != nil {
+            return nil, err
+        }
+        jobs = append(jobs, *job)
+    }
+
+    return jobs, rows.Err()
+}
+
+// FetchScheduledJob 取得單一排程工作的設定
+func FetchScheduledJob(name string) (*ScheduledJob, error) {
+    row := db.QueryRow("SELECT Name, Cron, Status, NextRunAt, LastRunAt FROM ScheduledJobs WHERE Name = ?", name)
+    job, err := scanScheduledJob(row)
+    if err == sql.ErrNoRows {
+        return nil, errJobNotFound
+    }
+    return job, err
+}
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanScheduledJob(row rowScanner) (*ScheduledJob, error) {
+    var job ScheduledJob
+    var nextRunAt, lastRunAt sql.NullString
+    if err := row.Scan(&job.Name, &job.Cron, &job.Status, &nextRunAt, &lastRunAt); err != nil {
+        return nil, err
+    }
+
+    var err error
+    if job.NextRunAt, err = parseNullTime(nextRunAt); err != nil {
+        return nil, err
+    }
+    if job.LastRunAt, err = parseNullTime(lastRunAt); err != nil {
+        return nil, err
+    }
+    return &job, nil
+}
+
+// parseNullTime 解析資料庫的 DATETIME 字串，NULL 時回傳 nil
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+    if !s.Valid {
+        return nil, nil
+    }
+    t, err := time.Parse("2006-01-02 15:04:05", s.String)
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+func saveJobStatus(name, status string) error {
+    _, err := db.Exec("UPDATE ScheduledJobs SET Status = ? WHERE Name = ?", status, name)
+    return err
+}
+
+func saveJobNextRun(name string, next *time.Time) error {
+    _, err := db.Exec("UPDATE ScheduledJobs SET NextRunAt = ? WHERE Name = ?", next, name)
+    return err
+}
+
+// GetScheduledJobs 列出所有排程工作
+func GetScheduledJobs(c *gin.Context) {
+    jobs, err := FetchScheduledJobs()
+    if err != nil {
+        log.Printf("FetchScheduledJobs error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取排程"})
+        return
+    }
+    c.JSON(http.StatusOK, jobs)
+}
+
+// UpdateJobHandler 修改排程工作的 cron 表達式
+func UpdateJobHandler(c *gin.Context) {
+    var req struct {
+        Cron string `json:"cron"`
+    }
+    if err := c.ShouldBindJSON(&req); err != nil || req.Cron == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+
+    err := scheduler.UpdateJobCron(c.Param("name"), req.Cron)
+    if err == errJobNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "排程已更新"})
+}
+
+// JobActionHandler 啟動、暫停、停止或立即執行排程工作
+func JobActionHandler(c *gin.Context) {
+    name := c.Param("name")
+
+    var err error
+    var message string
+    switch c.Param("action") {
+    case "start":
+        err = scheduler.StartJob(name)
+        message = "排程已啟動"
+    case "pause":
+        err = scheduler.PauseJob(name)
+        message = "排程已暫停"
+    case "stop":
+        err = scheduler.StopJob(name)
+        message = "排程已停止"
+    case "run":
+        err = scheduler.RunJobNow(name)
+        message = "已開始執行"
+    default:
+        c.JSON(http.StatusNotFound, gin.H{"error": "不支援的動作"})
+        return
+    }
+
+    if err == errJobNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err == errNotLeader {
+        lease, _ := FetchSchedulerLease()
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "lease": lease})
+        return
+    }
+    if err != nil {
+        log.Printf("JobActionHandler error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "排程操作失敗"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": message})
+}
@@ -2,6 +2,8 @@
 package api
 
 import (
+    "database/sql"
+    "log"
     "net/http"
     "github.com/gin-gonic/gin"
 )
@@ -15,8 +17,13 @@ import (
 func GetSpecificDateLimits(c *gin.Context)  {
     yearMonth := c.Query("month") // 從查詢參數中獲取月份，例如 "2024-01"
     specificDate := c.Query("date") // 新增：從查詢參數中獲取具體日期，例如 "2024-01-02"
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
 
-    allLimits, err := FetchSpecificDateLimits()
+    allLimits, err := FetchSpecificDateLimits(locationID)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取特定日期的時間限制數據"})
         return 
@@ -53,6 +60,12 @@ func GetSpecificDateLimits(c *gin.Context)  {
 
 // CreateSpecificDateLimit  創建特定日期的時段限制
 func CreateSpecificDateLimit(c *gin.Context)  {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     var dateLimits map[string]map[string]int
     if err := c.ShouldBind(&dateLimits); err != nil {
   
@@ -60,14 +73,58 @@ func CreateSpecificDateLimit(c *gin.Context)  {
         return 
     }
 
+    // 上限及變更紀錄在同一個交易中寫入，變更紀錄寫入失敗時整筆不生效
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("CreateSpecificDateLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
+        return
+    }
+    defer tx.Rollback()
+
+    actor := requestActor(c, auditSourceAPI)
     for date, limits := range dateLimits {
+        // 記錄變更前的上限
+        oldLimits := make(map[string]*int)
+        for timeSlot := range limits {
+            old, found, err := lockDateLimit(tx, locationID, date, timeSlot)
+            if err != nil {
+                log.Printf("CreateSpecificDateLimit error: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
+                return
+            }
+            if found {
+                oldLimits[timeSlot] = intPtr(old)
+            }
+        }
+
         dateLimit := SpecificDateLimit{
+            LocationID: locationID,
             Date:       date,
             TimeLimits: limits,
         }
-        if err := InsertSpecificDateLimit(dateLimit); err != nil {
-             c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
-             return
+        if err := InsertSpecificDateLimit(tx, dateLimit); err != nil {
+            log.Printf("CreateSpecificDateLimit error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
+            return
+        }
+        for timeSlot, limitCount := range limits {
+            if err := auditDateLimit(tx, actor, locationID, date, timeSlot, oldLimits[timeSlot], limitCount); err != nil {
+                log.Printf("CreateSpecificDateLimit audit error: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
+                return
+            }
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("CreateSpecificDateLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建特定日期的時段限制"})
+        return
+    }
+
+    for date, limits := range dateLimits {
+        for timeSlot := range limits {
+            capacityFreed(locationID, date, timeSlot)
         }
     }
      c.JSON(http.StatusCreated, dateLimits)
@@ -76,6 +133,12 @@ func CreateSpecificDateLimit(c *gin.Context)  {
 // UpdateSpecificDateLimit 更新特定日期的一個時段或多個限制
 
 func UpdateSpecificDateLimit(c *gin.Context)  {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     var dateLimits map[string]map[string]int
     if err := c.ShouldBind(&dateLimits); err != nil {
 
@@ -83,19 +146,34 @@ func UpdateSpecificDateLimit(c *gin.Context)  {
          return
     }
 
+    // 上限及變更紀錄在同一個交易中寫入，任一時段失敗時整筆不生效
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("UpdateSpecificDateLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "加入失敗"})
+        return
+    }
+    defer tx.Rollback()
+
+    actor := requestActor(c, auditSourceAPI)
     for date, timeLimits := range dateLimits {
         for timeSlot, limitCount := range timeLimits {
             // 檢查原本是否有這時段紀錄
-            if exists, err := checkDateLimitExists(date, timeSlot); err != nil {
+            if old, exists, err := lockDateLimit(tx, locationID, date, timeSlot); err != nil {
                  c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查時發生錯誤"})
                  return
             } else if exists {
                 // 有的話就改
-                err := UpdateDateLimit(date, timeSlot, limitCount)
+                err := UpdateDateLimit(tx, locationID, date, timeSlot, limitCount)
                 if err != nil {
                      c.JSON(http.StatusInternalServerError, gin.H{"error": "加入失敗"})
                      return
                 }
+                if err := auditDateLimit(tx, actor, locationID, date, timeSlot, intPtr(old), limitCount); err != nil {
+                    log.Printf("UpdateSpecificDateLimit audit error: %v", err)
+                    c.JSON(http.StatusInternalServerError, gin.H{"error": "加入失敗"})
+                    return
+                }
             } else {
                 // 沒有的話給錯誤或插入新時段
                  c.JSON(http.StatusNotFound, gin.H{"error": "沒有這個時段設定"})
@@ -104,33 +182,37 @@ func UpdateSpecificDateLimit(c *gin.Context)  {
             }
         }
     }
-     c.JSON(http.StatusOK, gin.H{"result": "已加入訂單"})
-}
+    if err := tx.Commit(); err != nil {
+        log.Printf("UpdateSpecificDateLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "加入失敗"})
+        return
+    }
 
-// checkDateLimitExists 檢查日期時段有無
-func checkDateLimitExists(date string, timeSlot string) (bool, error) {
-    var exists bool
-    err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM DateLimits WHERE Date = ? AND TimeSlot = ?)", date, timeSlot).Scan(&exists)
-    if err != nil {
-        return false, err
+    for date, timeLimits := range dateLimits {
+        for timeSlot := range timeLimits {
+            capacityFreed(locationID, date, timeSlot)
+        }
     }
-    return exists, nil
+     c.JSON(http.StatusOK, gin.H{"result": "已加入訂單"})
 }
 
-
-// UpdateDateLimit 更新特定時段
-func UpdateDateLimit(date string, timeSlot string, limitCount int) error  {
-    stmt, err := db.Prepare("UPDATE DateLimits SET LimitCount = ? WHERE Date = ? AND TimeSlot = ?")
-    if err != nil {
-        return err
+// lockDateLimit 在交易中鎖定並取得某日期時段的上限，沒有設定時 found 為 false
+func lockDateLimit(tx *sql.Tx, locationID int, date, timeSlot string) (limit int, found bool, err error) {
+    err = tx.QueryRow("SELECT LimitCount FROM DateLimits WHERE LocationID = ? AND Date = ? AND TimeSlot = ? FOR UPDATE",
+        locationID, date, timeSlot).Scan(&limit)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
     }
-    defer stmt.Close()
-
-    _, err = stmt.Exec(limitCount, date, timeSlot)
     if err != nil {
-        return err
+        return 0, false, err
     }
+    return limit, true, nil
+}
 
-    return nil
+// UpdateDateLimit 更新特定時段
+func UpdateDateLimit(tx *sql.Tx, locationID int, date string, timeSlot string, limitCount int) error  {
+    _, err := tx.Exec("UPDATE DateLimits SET LimitCount = ?, Origin = 'manual' WHERE LocationID = ? AND Date = ? AND TimeSlot = ?",
+        limitCount, locationID, date, timeSlot)
+    return err
 }
 
@@ -2,7 +2,8 @@
 package api
 
 import (
-
+    "database/sql"
+    "log"
     "net/http"
     "github.com/gin-gonic/gin"
 )
@@ -11,7 +12,13 @@ import (
 
 // GetTimeSlotLimits 處理函數， 獲取所有時段限制
 func GetTimeSlotLimits(c *gin.Context)  {
-    limits, err := FetchTimeSlotLimits()
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    limits, err := FetchTimeSlotLimits(locationID)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取時段限制資料"})
         return
@@ -38,11 +45,38 @@ func CreateTimeSlotLimit(c *gin.Context)  {
          c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
          return
     }
+    // 沒有指定據點時使用預設據點
+    if limit.LocationID == 0 {
+        limit.LocationID = defaultLocationID
+    }
 
     // 将单个对象转换为切片
     limits := TimeSlotLimits{limit}
 
-    if err := InsertTimeSlotLimits(limits); err != nil {
+    // 預設及變更紀錄在同一個交易中寫入
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("CreateTimeSlotLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建時段限制"})
+        return
+    }
+    defer tx.Rollback()
+
+    if err := InsertTimeSlotLimits(tx, limits); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建時段限制"})
+        return
+    }
+    err = recordAudit(tx, requestActor(c, auditSourceAPI), CapacityAudit{
+        LocationID: limit.LocationID,
+        Scope:      "template",
+        TimeSlot:   limit.TimeSlot,
+        NewValue:   intPtr(limit.LimitCount),
+    })
+    if err == nil {
+        err = tx.Commit()
+    }
+    if err != nil {
+        log.Printf("CreateTimeSlotLimit error: %v", err)
         c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建時段限制"})
         return
     }
@@ -52,15 +86,81 @@ func CreateTimeSlotLimit(c *gin.Context)  {
 
 // UpdateTimeSlotLimit  更新現有的時段限制
 func UpdateTimeSlotLimit(c *gin.Context)  {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     var limits map[string]int
     if err := c.ShouldBind(&limits); err != nil {
         c.JSON(http.StatusBadRequest,gin.H{"error": "無效輸入"})
         return
     }
 
-    if err := UpdateExistingTimeSlotLimits(limits); err != nil {
+    // 預設及變更紀錄在同一個交易中寫入，變更紀錄寫入失敗時整筆不生效
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("UpdateTimeSlotLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+        return
+    }
+    defer tx.Rollback()
+
+    // 記錄變更前的預設
+    oldLimits, err := lockTimeSlotLimits(tx, locationID)
+    if err != nil {
+        log.Printf("UpdateTimeSlotLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+        return
+    }
+
+    if err := UpdateExistingTimeSlotLimits(tx, locationID, limits); err != nil {
        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
        return 
     }
+
+    actor := requestActor(c, auditSourceAPI)
+    for timeSlot, oldValue := range oldLimits {
+        if newValue, ok := limits[timeSlot]; ok && newValue != oldValue {
+            err := recordAudit(tx, actor, CapacityAudit{
+                LocationID: locationID,
+                Scope:      "template",
+                TimeSlot:   timeSlot,
+                OldValue:   intPtr(oldValue),
+                NewValue:   intPtr(newValue),
+            })
+            if err != nil {
+                log.Printf("UpdateTimeSlotLimit audit error: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+                return
+            }
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("UpdateTimeSlotLimit error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+        return
+    }
     c.JSON(http.StatusOK, gin.H{"result": "更新成功"})
 }
+
+// lockTimeSlotLimits 在交易中鎖定並取得據點的預設，以時段為 key
+func lockTimeSlotLimits(tx *sql.Tx, locationID int) (map[string]int, error) {
+    rows, err := tx.Query("SELECT TimeSlot, LimitCount FROM TimeSlotLimits WHERE LocationID = ? FOR UPDATE", locationID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    limits := make(map[string]int)
+    for rows.Next() {
+        var timeSlot string
+        var limitCount int
+        if err := rows.Scan(&timeSlot, &limitCount); err != nil {
+            return nil, err
+        }
+        limits[timeSlot] = limitCount
+    }
+    return limits, rows.Err()
+}
@@ -0,0 +1,267 @@
+// waitlist.go
+package api
+
+import (
+    "crypto/subtle"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// WaitlistNotifier 通知候補客人有名額釋出
+type WaitlistNotifier interface {
+    NotifyOffer(entry WaitlistEntry, hold CapacityHold) error
+}
+
+// logNotifier 沒有串接簡訊或 Email 時只寫入日誌
+type logNotifier struct{}
+
+func (logNotifier) NotifyOffer(entry WaitlistEntry, hold CapacityHold) error {
+    log.Printf("候補通知: %s (%s) %s %s 共 %d 份，請在 %s 前使用憑證 %s 下單",
+        entry.Name, entry.Mobile, entry.Date, entry.TimeSlot, entry.Quantity,
+        hold.ExpiresAt.Format("2006-01-02 15:04:05"), hold.Token)
+    return nil
+}
+
+var waitlistNotifier WaitlistNotifier = logNotifier{}
+
+// SetWaitlistNotifier 替換候補通知方式
+func SetWaitlistNotifier(n WaitlistNotifier) {
+    waitlistNotifier = n
+}
+
+// 預設候補名額保留的時間
+const defaultOfferTTL = 30 * time.Minute
+
+// offerTTL 從環境變數 WAITLIST_OFFER_MINUTES 讀取候補名額保留時間
+func offerTTL() time.Duration {
+    minutes, err := strconv.Atoi(os.Getenv("WAITLIST_OFFER_MINUTES"))
+    if err != nil || minutes <= 0 {
+        return defaultOfferTTL
+    }
+    return time.Duration(minutes) * time.Minute
+}
+
+// JoinWaitlist 時段額滿時登記候補
+func JoinWaitlist(c *gin.Context) {
+    var entry WaitlistEntry
+    err := c.ShouldBindJSON(&entry)
+    if err != nil || entry.Date == "" || entry.TimeSlot == "" || entry.Mobile == "" || entry.Quantity <= 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if entry.LocationID == 0 {
+        entry.LocationID = defaultLocationID
+    }
+
+    entry.CancelToken, err = newHoldToken()
+    if err != nil {
+        log.Printf("JoinWaitlist error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法登記候補"})
+        return
+    }
+
+    res, err := db.Exec("INSERT INTO Waitlist (LocationID, Date, TimeSlot, Name, Mobile, Email, Quantity, Status, CancelToken) VALUES (?, ?, ?, ?, ?, ?, ?, 'waiting', ?)",
+        entry.LocationID, entry.Date, entry.TimeSlot, entry.Name, entry.Mobile, entry.Email, entry.Quantity, entry.CancelToken)
+    if err != nil {
+        log.Printf("JoinWaitlist error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法登記候補"})
+        return
+    }
+    id, _ := res.LastInsertId()
+    entry.ID = int(id)
+    entry.Status = "waiting"
+
+    // 時段其實還有名額時直接提供給這位候補
+    capacityFreed(entry.LocationID, entry.Date, entry.TimeSlot)
+
+    c.JSON(http.StatusCreated, entry)
+}
+
+// GetWaitlist 取得據點某日期 (可指定時段) 的候補名單
+func GetWaitlist(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    date := c.Query("date")
+    if date == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供日期"})
+        return
+    }
+
+    entries, err := FetchWaitlist(locationID, date, c.Query("time_slot"))
+    if err != nil {
+        log.Printf("FetchWaitlist error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取候補名單"})
+        return
+    }
+
+    c.JSON(http.StatusOK, entries)
+}
+
+// CancelWaitlist 取消候補，已提供的名額一併釋放。客人需以 X-Cancel-Token 帶上登記時取得的取消憑證，管理員不需要
+func CancelWaitlist(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "無效的候補 ID"})
+        return
+    }
+
+    var entry WaitlistEntry
+    err = db.QueryRow("SELECT LocationID, Date, TimeSlot, Status, COALESCE(HoldToken, ''), COALESCE(CancelToken, '') FROM Waitlist WHERE ID = ?", id).Scan(
+        &entry.LocationID, &entry.Date, &entry.TimeSlot, &entry.Status, &entry.HoldToken, &entry.CancelToken)
+    if err != nil || (entry.Status != "waiting" && entry.Status != "offered") {
+        c.JSON(http.StatusNotFound, gin.H{"error": "沒有可取消的候補"})
+        return
+    }
+    // 沒有憑證的舊候補只能由管理員取消
+    if !isAdminRequest(c) {
+        token := c.GetHeader("X-Cancel-Token")
+        if entry.CancelToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(entry.CancelToken)) != 1 {
+            c.JSON(http.StatusForbidden, gin.H{"error": "取消憑證錯誤"})
+            return
+        }
+    }
+
+    if _, err := db.Exec("UPDATE Waitlist SET Status = 'cancelled' WHERE ID = ?", id); err != nil {
+        log.Printf("CancelWaitlist error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法取消候補"})
+        return
+    }
+    if entry.HoldToken != "" {
+        if _, err := ReleaseHold(entry.HoldToken); err != nil {
+            log.Printf("ReleaseHold error: %v", err)
+        }
+        capacityFreed(entry.LocationID, entry.Date, entry.TimeSlot)
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "已取消候補"})
+}
+
+// FetchWaitlist 依登記順序取得候補名單
+func FetchWaitlist(locationID int, date, timeSlot string) ([]WaitlistEntry, error) {
+    query := "SELECT ID, LocationID, Date, TimeSlot, Name, Mobile, Email, Quantity, Status, COALESCE(HoldToken, '') FROM Waitlist WHERE LocationID = ? AND Date = ?"
+    args := []interface{}{locationID, date}
+    if timeSlot != "" {
+        query += " AND TimeSlot = ?"
+        args = append(args, timeSlot)
+    }
+    query += " ORDER BY TimeSlot, ID"
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []WaitlistEntry
+    for rows.Next() {
+        var entry WaitlistEntry
+        if err := rows.Scan(&entry.ID, &entry.LocationID, &entry.Date, &entry.TimeSlot, &entry.Name, &entry.Mobile, &entry.Email, &entry.Quantity, &entry.Status, &entry.HoldToken); err != nil {
+            return nil, err
+        }
+        entries = append(entries, entry)
+    }
+
+    return entries, nil
+}
+
+// capacityFreed 名額釋出時 (訂單作廢、上限調高、保留過期) 依序提供給候補，錯誤只記錄不中斷呼叫端
+func capacityFreed(locationID int, date, timeSlot string) {
+    if err := OfferWaitlist(locationID, date, timeSlot); err != nil {
+        log.Printf("OfferWaitlist error: %v", err)
+    }
+}
+
+// OfferWaitlist 依登記順序為候補保留名額並通知，排在前面的候補名額不足時就停止
+func OfferWaitlist(locationID int, date, timeSlot string) error {
+    // 已超過截止時間的時段不再提供
+    if err := checkCutoff(locationID, date, timeSlot); err != nil {
+        if err == errPastCutoff {
+            return nil
+        }
+        return err
+    }
+
+    entries, err := FetchWaitlist(locationID, date, timeSlot)
+    if err != nil {
+        return err
+    }
+
+    for _, entry := range entries {
+        if entry.Status != "waiting" {
+            continue
+        }
+
+        hold, offered, err := offerWaitlistEntry(entry)
+        if err == errSlotFull {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if !offered {
+            continue
+        }
+        entry.Status = "offered"
+        entry.HoldToken = hold.Token
+
+        if err := waitlistNotifier.NotifyOffer(entry, hold); err != nil {
+            log.Printf("NotifyOffer error: %v", err)
+        }
+    }
+
+    return nil
+}
+
+// offerWaitlistEntry 在同一個交易中保留名額並領取候補，候補已被取消或提供給別人時 offered 為 false
+func offerWaitlistEntry(entry WaitlistEntry) (hold CapacityHold, offered bool, err error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return hold, false, err
+    }
+    defer tx.Rollback()
+
+    hold, err = insertHoldTx(tx, CapacityHold{
+        LocationID: entry.LocationID,
+        Date:       entry.Date,
+        TimeSlot:   entry.TimeSlot,
+        Quantity:   entry.Quantity,
+    }, offerTTL())
+    if err != nil {
+        return hold, false, err
+    }
+
+    // 只領取仍在等待的候補，同時有其他請求提供名額時不會重複保留
+    res, err := tx.Exec("UPDATE Waitlist SET Status = 'offered', HoldToken = ? WHERE ID = ? AND Status = 'waiting'", hold.Token, entry.ID)
+    if err != nil {
+        return hold, false, err
+    }
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return hold, false, err
+    }
+    if affected != 1 {
+        return hold, false, nil
+    }
+    return hold, true, tx.Commit()
+}
+
+// expireWaitlistOffers 保留已過期或被釋放的候補標記為 expired
+func expireWaitlistOffers() error {
+    _, err := db.Exec(`UPDATE Waitlist w JOIN CapacityHolds h ON h.Token = w.HoldToken
+        SET w.Status = 'expired' WHERE w.Status = 'offered' AND h.Status IN ('expired', 'released')`)
+    return err
+}
+
+// acceptWaitlistOffer 候補使用保留憑證下單後標記為 accepted
+func acceptWaitlistOffer(q execer, token string) error {
+    _, err := q.Exec("UPDATE Waitlist SET Status = 'accepted' WHERE HoldToken = ? AND Status = 'offered'", token)
+    return err
+}
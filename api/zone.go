@@ -0,0 +1,383 @@
+// zone.go
+package api
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// GetDeliveryZones 取得據點的所有外送區域
+func GetDeliveryZones(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    zones, err := FetchDeliveryZones(locationID)
+    if err != nil {
+        log.Printf("FetchDeliveryZones error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取外送區域"})
+        return
+    }
+
+    c.JSON(http.StatusOK, zones)
+}
+
+// CreateDeliveryZone 新增外送區域，涵蓋的城市與路名需存在於路名資料
+func CreateDeliveryZone(c *gin.Context) {
+    var zone DeliveryZone
+    if err := c.ShouldBindJSON(&zone); err != nil || zone.Name == "" || len(zone.Areas) == 0 || zone.DeliveryFee < 0 || zone.FreeDeliveryOver < 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    if zone.LocationID == 0 {
+        zone.LocationID = defaultLocationID
+    }
+
+    for _, area := range zone.Areas {
+        ok, err := areaExists(area)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "檢查路名時發生錯誤"})
+            return
+        }
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "沒有這個城市或路名", "area": area})
+            return
+        }
+    }
+
+    id, err := InsertDeliveryZone(zone)
+    if err != nil {
+        log.Printf("InsertDeliveryZone error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法新增外送區域"})
+        return
+    }
+    zone.ID = id
+
+    c.JSON(http.StatusCreated, zone)
+}
+
+// GetZoneLimits 取得據點某日期各區域的外送上限及已訂數量
+func GetZoneLimits(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    date := c.Query("date")
+    if date == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "請提供日期"})
+        return
+    }
+
+    limits, err := FetchZoneLimits(locationID, date)
+    if err != nil {
+        log.Printf("FetchZoneLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取區域上限"})
+        return
+    }
+
+    c.JSON(http.StatusOK, limits)
+}
+
+// SetZoneLimits 新增或更新區域外送上限
+func SetZoneLimits(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    var limits []ZoneLimit
+    if err := c.ShouldBindJSON(&limits); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+    for _, limit := range limits {
+        if limit.LimitCount < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "上限不可為負數"})
+            return
+        }
+    }
+
+    // 上限及變更紀錄在同一個交易中寫入
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("SetZoneLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+        return
+    }
+    defer tx.Rollback()
+
+    actor := requestActor(c, auditSourceAPI)
+    for _, limit := range limits {
+        exists, err := zoneInLocation(tx, locationID, limit.ZoneID)
+        if err != nil {
+            log.Printf("SetZoneLimits error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+            return
+        }
+        if !exists {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("區域 %d 不屬於此據點", limit.ZoneID)})
+            return
+        }
+
+        old, found, err := zoneLimitCount(tx, locationID, limit.Date, limit.TimeSlot, limit.ZoneID)
+        if err != nil {
+            log.Printf("FetchZoneLimit error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+            return
+        }
+        if err := UpsertZoneLimit(tx, locationID, limit); err != nil {
+            log.Printf("UpsertZoneLimit error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+            return
+        }
+
+        entry := CapacityAudit{
+            LocationID: locationID,
+            Scope:      "zone",
+            Date:       limit.Date,
+            TimeSlot:   limit.TimeSlot,
+            ZoneID:     limit.ZoneID,
+            NewValue:   intPtr(limit.LimitCount),
+        }
+        if found {
+            entry.OldValue = intPtr(old)
+        }
+        if !found || old != limit.LimitCount {
+            if err := recordAudit(tx, actor, entry); err != nil {
+                log.Printf("SetZoneLimits audit error: %v", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+                return
+            }
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("SetZoneLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"result": "更新成功"})
+}
+
+// DeleteZoneLimits 移除區域外送上限，只保留時段總上限
+func DeleteZoneLimits(c *gin.Context) {
+    locationID, err := parseLocationID(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    var limits []ZoneLimit
+    if err := c.ShouldBindJSON(&limits); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "格式錯誤"})
+        return
+    }
+
+    // 刪除及變更紀錄在同一個交易中寫入
+    tx, err := db.Begin()
+    if err != nil {
+        log.Printf("DeleteZoneLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+        return
+    }
+    defer tx.Rollback()
+
+    actor := requestActor(c, auditSourceAPI)
+    for _, limit := range limits {
+        old, found, err := zoneLimitCount(tx, locationID, limit.Date, limit.TimeSlot, limit.ZoneID)
+        if err != nil {
+            log.Printf("FetchZoneLimit error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+            return
+        }
+        if !found {
+            continue
+        }
+
+        _, err = tx.Exec("DELETE FROM ZoneLimits WHERE LocationID = ? AND Date = ? AND TimeSlot = ? AND ZoneID = ?",
+            locationID, limit.Date, limit.TimeSlot, limit.ZoneID)
+        if err != nil {
+            log.Printf("DeleteZoneLimits error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+            return
+        }
+        err = recordAudit(tx, actor, CapacityAudit{
+            LocationID: locationID,
+            Scope:      "zone",
+            Date:       limit.Date,
+            TimeSlot:   limit.TimeSlot,
+            ZoneID:     limit.ZoneID,
+            OldValue:   intPtr(old),
+        })
+        if err != nil {
+            log.Printf("DeleteZoneLimits audit error: %v", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+            return
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        log.Printf("DeleteZoneLimits error: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除失敗"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"result": "刪除成功"})
+}
+
+// areaExists 用路名資料確認城市 (或城市中的路名) 存在
+func areaExists(area ZoneArea) (bool, error) {
+    var exists bool
+    var err error
+    if area.Road == "" {
+        err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM roads WHERE city_id = ?)", area.CityID).Scan(&exists)
+    } else {
+        err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM roads WHERE city_id = ? AND name = ?)", area.CityID, area.Road).Scan(&exists)
+    }
+    return exists, err
+}
+
+// zoneInLocation 區域是否存在且屬於據點
+func zoneInLocation(q queryer, locationID, zoneID int) (bool, error) {
+    var exists bool
+    err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM DeliveryZones WHERE ID = ? AND LocationID = ?)", zoneID, locationID).Scan(&exists)
+    return exists, err
+}
+
+// ResolveZoneID 依城市與路名找出地址所屬區域，路名設定優先於整個城市，找不到時回傳 0
+func ResolveZoneID(locationID, cityID int, road string) (int, error) {
+    var zoneID int
+    err := db.QueryRow(`SELECT a.ZoneID FROM DeliveryZoneAreas a
+        JOIN DeliveryZones z ON z.ID = a.ZoneID
+        WHERE z.LocationID = ? AND a.CityID = ? AND (a.Road = ? OR a.Road = '')
+        ORDER BY a.Road = '' LIMIT 1`, locationID, cityID, road).Scan(&zoneID)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return zoneID, err
+}
+
+// FetchZoneLimit 取得區域在某日期時段的上限，沒有設定時 found 為 false
+func FetchZoneLimit(locationID int, date, timeSlot string, zoneID int) (limit int, found bool, err error) {
+    return zoneLimitCount(db, locationID, date, timeSlot, zoneID)
+}
+
+func zoneLimitCount(q queryer, locationID int, date, timeSlot string, zoneID int) (limit int, found bool, err error) {
+    err = q.QueryRow("SELECT LimitCount FROM ZoneLimits WHERE LocationID = ? AND Date = ? AND TimeSlot = ? AND ZoneID = ?",
+        locationID, date, timeSlot, zoneID).Scan(&limit)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return limit, true, nil
+}
+
+// FetchZoneDrops 統計區域在某日期時段非作廢的外送筆數
+func FetchZoneDrops(locationID int, date, timeSlot string, zoneID int) (int, error) {
+    return zoneDrops(db, locationID, date, timeSlot, zoneID)
+}
+
+func zoneDrops(q queryer, locationID int, date, timeSlot string, zoneID int) (int, error) {
+    var drops int
+    err := q.QueryRow("SELECT COUNT(*) FROM orders WHERE location_id = ? AND delivery_date = ? AND delivery_time_range = ? AND zone_id = ? AND status_code NOT IN (" + releasedStatuses + ")",
+        locationID, date, timeSlot, zoneID).Scan(&drops)
+    return drops, err
+}
+
+// FetchDeliveryZones 取得據點的外送區域及涵蓋範圍
+func FetchDeliveryZones(locationID int) ([]DeliveryZone, error) {
+    rows, err := db.Query(`SELECT z.ID, z.Name, z.DeliveryFee, z.FreeDeliveryOver, a.CityID, a.Road FROM DeliveryZones z
+        LEFT JOIN DeliveryZoneAreas a ON a.ZoneID = z.ID
+        WHERE z.LocationID = ? ORDER BY z.ID, a.CityID, a.Road`, locationID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var zones []DeliveryZone
+    for rows.Next() {
+        var id, fee, freeOver int
+        var name string
+        var cityID sql.NullInt64
+        var road sql.NullString
+        if err := rows.Scan(&id, &name, &fee, &freeOver, &cityID, &road); err != nil {
+            return nil, err
+        }
+        if len(zones) == 0 || zones[len(zones)-1].ID != id {
+            zones = append(zones, DeliveryZone{ID: id, LocationID: locationID, Name: name, DeliveryFee: fee, FreeDeliveryOver: freeOver})
+        }
+        if cityID.Valid {
+            zone := &zones[len(zones)-1]
+            zone.Areas = append(zone.Areas, ZoneArea{CityID: int(cityID.Int64), Road: road.String})
+        }
+    }
+
+    return zones, nil
+}
+
+// InsertDeliveryZone 新增區域及涵蓋範圍
+func InsertDeliveryZone(zone DeliveryZone) (int, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec("INSERT INTO DeliveryZones (LocationID, Name, DeliveryFee, FreeDeliveryOver) VALUES (?, ?, ?, ?)",
+        zone.LocationID, zone.Name, zone.DeliveryFee, zone.FreeDeliveryOver)
+    if err != nil {
+        return 0, err
+    }
+    zoneID, err := res.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+
+    for _, area := range zone.Areas {
+        _, err := tx.Exec("INSERT INTO DeliveryZoneAreas (ZoneID, CityID, Road) VALUES (?, ?, ?)", zoneID, area.CityID, area.Road)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    return int(zoneID), tx.Commit()
+}
+
+// FetchZoneLimits 取得據點某日期的所有區域上限
+func FetchZoneLimits(locationID int, date string) ([]ZoneLimit, error) {
+    rows, err := db.Query(`SELECT DATE_FORMAT(l.Date, '%Y-%m-%d'), l.TimeSlot, l.ZoneID, l.LimitCount,
+            (SELECT COUNT(*) FROM orders o WHERE o.location_id = l.LocationID AND o.delivery_date = l.Date
+                AND o.delivery_time_range = l.TimeSlot AND o.zone_id = l.ZoneID AND o.status_code NOT IN (` + releasedStatuses + `))
+        FROM ZoneLimits l WHERE l.LocationID = ? AND l.Date = ? ORDER BY l.TimeSlot, l.ZoneID`, locationID, date)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var limits []ZoneLimit
+    for rows.Next() {
+        var limit ZoneLimit
+        if err := rows.Scan(&limit.Date, &limit.TimeSlot, &limit.ZoneID, &limit.LimitCount, &limit.Booked); err != nil {
+            return nil, err
+        }
+        limits = append(limits, limit)
+    }
+
+    return limits, nil
+}
+
+// UpsertZoneLimit 新增或更新區域上限
+func UpsertZoneLimit(q execer, locationID int, limit ZoneLimit) error {
+    _, err := q.Exec("INSERT INTO ZoneLimits (LocationID, Date, TimeSlot, ZoneID, LimitCount) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE LimitCount = VALUES(LimitCount)",
+        locationID, limit.Date, limit.TimeSlot, limit.ZoneID, limit.LimitCount)
+    return err
+}
@@ -2,8 +2,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"onlineBingGin/api"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -52,6 +57,15 @@ func main() {
 	// 初始化數據庫連接
 	api.InitDB()
 
+	// 收到關閉訊號時取消排程並關閉服務
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 啟動排程，恢復上次的排程狀態
+	if err := api.StartScheduler(ctx); err != nil {
+		log.Fatalf("排程啟動失敗: %v", err)
+	}
+
 	// 創建 Gin 實例
 	r := gin.Default()
 
@@ -87,5 +101,20 @@ func main() {
 
 
 	// 啟動服務
-	r.Run(":8080")
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("伺服器啟動失敗: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("伺服器關閉中")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("伺服器關閉失敗: %v", err)
+	}
+	api.StopScheduler()
 }
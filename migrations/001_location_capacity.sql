@@ -0,0 +1,12 @@
+-- 001_location_capacity.sql
+-- 時段限制依據點 (LocationID) 區分，既有資料歸入預設據點 1
+
+ALTER TABLE TimeSlotLimits
+    ADD COLUMN LocationID INT NOT NULL DEFAULT 1 FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (LocationID, TimeSlot);
+
+ALTER TABLE DateLimits
+    ADD COLUMN LocationID INT NOT NULL DEFAULT 1 FIRST,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (LocationID, Date, TimeSlot);
@@ -0,0 +1,28 @@
+-- 002_delivery_zones.sql
+-- 外送區域及各區域在日期時段的外送上限
+
+CREATE TABLE IF NOT EXISTS DeliveryZones (
+    ID         INT AUTO_INCREMENT PRIMARY KEY,
+    LocationID INT NOT NULL DEFAULT 1,
+    Name       VARCHAR(100) NOT NULL
+);
+
+-- Road 為空字串代表涵蓋整個城市
+CREATE TABLE IF NOT EXISTS DeliveryZoneAreas (
+    ZoneID INT NOT NULL,
+    CityID INT NOT NULL,
+    Road   VARCHAR(100) NOT NULL DEFAULT '',
+    PRIMARY KEY (ZoneID, CityID, Road),
+    KEY idx_city_road (CityID, Road)
+);
+
+CREATE TABLE IF NOT EXISTS ZoneLimits (
+    LocationID INT NOT NULL DEFAULT 1,
+    Date       DATE NOT NULL,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    ZoneID     INT NOT NULL,
+    LimitCount INT NOT NULL,
+    PRIMARY KEY (LocationID, Date, TimeSlot, ZoneID)
+);
+
+ALTER TABLE orders ADD COLUMN zone_id INT NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+-- 003_capacity_holds.sql
+-- 結帳期間暫時保留的名額，Status: active、converted、released、expired
+
+CREATE TABLE IF NOT EXISTS CapacityHolds (
+    Token      CHAR(32) PRIMARY KEY,
+    LocationID INT NOT NULL DEFAULT 1,
+    Date       DATE NOT NULL,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    Quantity   INT NOT NULL,
+    ExpiresAt  DATETIME NOT NULL,
+    Status     VARCHAR(20) NOT NULL DEFAULT 'active',
+    OrderID    INT NULL,
+    CreatedAt  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_slot_status (LocationID, Date, TimeSlot, Status)
+);
@@ -0,0 +1,19 @@
+-- 004_waitlist.sql
+-- 額滿時段的候補，Status: waiting、offered、accepted、expired、cancelled
+-- 提供名額時以 CapacityHolds 保留，HoldToken 即客人下單用的憑證
+
+CREATE TABLE IF NOT EXISTS Waitlist (
+    ID         INT AUTO_INCREMENT PRIMARY KEY,
+    LocationID INT NOT NULL DEFAULT 1,
+    Date       DATE NOT NULL,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    Name       VARCHAR(100) NOT NULL DEFAULT '',
+    Mobile     VARCHAR(30) NOT NULL,
+    Email      VARCHAR(255) NOT NULL DEFAULT '',
+    Quantity   INT NOT NULL,
+    Status     VARCHAR(20) NOT NULL DEFAULT 'waiting',
+    HoldToken  CHAR(32) NULL,
+    CreatedAt  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_slot_status (LocationID, Date, TimeSlot, Status),
+    KEY idx_hold_token (HoldToken)
+);
@@ -0,0 +1,11 @@
+-- 005_scheduled_jobs.sql
+-- 排程工作設定，Status: active、paused、stopped，伺服器重新啟動後依此恢復
+
+CREATE TABLE IF NOT EXISTS ScheduledJobs (
+    Name      VARCHAR(100) PRIMARY KEY,
+    Cron      VARCHAR(100) NOT NULL,
+    Status    VARCHAR(20) NOT NULL DEFAULT 'stopped',
+    NextRunAt DATETIME NULL,
+    LastRunAt DATETIME NULL,
+    UpdatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
@@ -0,0 +1,13 @@
+-- 006_job_runs.sql
+-- 排程工作的執行紀錄，Status: running、success、failed
+
+CREATE TABLE IF NOT EXISTS JobRuns (
+    ID         INT AUTO_INCREMENT PRIMARY KEY,
+    JobName    VARCHAR(100) NOT NULL,
+    StartedAt  DATETIME NOT NULL,
+    FinishedAt DATETIME NULL,
+    Status     VARCHAR(20) NOT NULL,
+    Error      TEXT NULL,
+    Summary    VARCHAR(500) NOT NULL DEFAULT '',
+    KEY idx_job_status (JobName, Status)
+);
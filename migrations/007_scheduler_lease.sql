@@ -0,0 +1,10 @@
+-- 007_scheduler_lease.sql
+-- 多台伺服器時只有持有租約的伺服器執行排程工作
+
+CREATE TABLE IF NOT EXISTS SchedulerLeases (
+    Name      VARCHAR(100) PRIMARY KEY,
+    Holder    VARCHAR(255) NOT NULL,
+    ExpiresAt DATETIME NOT NULL
+);
+
+ALTER TABLE JobRuns ADD COLUMN Instance VARCHAR(255) NOT NULL DEFAULT '' AFTER JobName;
@@ -0,0 +1,10 @@
+-- 008_slot_cutoffs.sql
+-- 各據點時段的下單截止規則，沒有規則的時段以時段開始時間截止
+
+CREATE TABLE IF NOT EXISTS SlotCutoffs (
+    LocationID INT NOT NULL DEFAULT 1,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    DaysBefore INT NOT NULL DEFAULT 0,
+    CutoffTime TIME NOT NULL,
+    PRIMARY KEY (LocationID, TimeSlot)
+);
@@ -0,0 +1,20 @@
+-- 009_capacity_audit.sql
+-- 上限及預設模板的變更紀錄，只新增不修改
+-- Scope: template、date、zone；Source: api、scheduler、bulk
+-- OldValue 為 NULL 表示新增，NewValue 為 NULL 表示刪除
+
+CREATE TABLE IF NOT EXISTS CapacityAudit (
+    ID         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    LocationID INT NOT NULL DEFAULT 1,
+    Scope      VARCHAR(20) NOT NULL,
+    Date       DATE NULL,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    ZoneID     INT NOT NULL DEFAULT 0,
+    OldValue   INT NULL,
+    NewValue   INT NULL,
+    Actor      VARCHAR(255) NOT NULL,
+    Source     VARCHAR(20) NOT NULL,
+    CreatedAt  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_date_slot (Date, TimeSlot),
+    KEY idx_actor (Actor)
+);
@@ -0,0 +1,12 @@
+-- 010_date_limit_origin.sql
+-- 記錄每個時段上限的來源，自動新增覆蓋時保留人工修改過的時段
+-- Origin: template (手動觸發自動新增)、auto (排程自動新增)、manual (人工修改)
+-- 既有資料與預設模板相同時視為 auto，不同或模板沒有這個時段時視為 manual，避免覆蓋人工設定
+
+ALTER TABLE DateLimits
+    ADD COLUMN Origin VARCHAR(20) NOT NULL DEFAULT 'auto';
+
+UPDATE DateLimits d
+    LEFT JOIN TimeSlotLimits t ON t.LocationID = d.LocationID AND t.TimeSlot = d.TimeSlot
+SET d.Origin = 'manual'
+WHERE t.LimitCount IS NULL OR t.LimitCount <> d.LimitCount;
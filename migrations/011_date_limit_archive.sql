@@ -0,0 +1,14 @@
+-- 011_date_limit_archive.sql
+-- 過期的時段上限連同當時的已訂份數移到封存表供報表使用，之後從 DateLimits 及 Dates 刪除
+
+CREATE TABLE IF NOT EXISTS DateLimitArchive (
+    LocationID INT NOT NULL DEFAULT 1,
+    Date       DATE NOT NULL,
+    TimeSlot   VARCHAR(50) NOT NULL,
+    LimitCount INT NOT NULL,
+    Booked     INT NOT NULL DEFAULT 0,
+    Origin     VARCHAR(20) NOT NULL DEFAULT 'auto',
+    ArchivedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (LocationID, Date, TimeSlot),
+    KEY idx_date (Date)
+);
@@ -0,0 +1,21 @@
+-- 012_order_status_history.sql
+-- 訂單狀態改為固定的狀態機，每次轉換都記錄在 order_status_history
+-- 狀態: pending、paid、confirmed、preparing、out_for_delivery、delivered、cancelled、refunded
+-- 原本的 Void 視為 cancelled，其他無法辨識的狀態視為 pending
+
+UPDATE orders SET status_code = 'cancelled' WHERE status_code = 'Void';
+UPDATE orders SET status_code = 'pending'
+    WHERE status_code IS NULL
+       OR status_code NOT IN ('pending', 'paid', 'confirmed', 'preparing', 'out_for_delivery', 'delivered', 'cancelled', 'refunded');
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    order_id    INT NOT NULL,
+    from_status VARCHAR(30) NOT NULL DEFAULT '',
+    to_status   VARCHAR(30) NOT NULL,
+    actor       VARCHAR(255) NOT NULL,
+    source      VARCHAR(20) NOT NULL,
+    reason      VARCHAR(500) NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_order (order_id)
+);
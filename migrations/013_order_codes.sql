@@ -0,0 +1,31 @@
+-- 013_order_codes.sql
+-- 訂單編號改由伺服器產生，格式為 日期-據點-流水號-檢查碼，例如 241019-01-0042-9
+-- 流水號依建立日期及據點各自從 1 開始，既有的數字編號保留不變
+
+ALTER TABLE orders
+    MODIFY COLUMN code VARCHAR(32) NULL;
+
+-- 加上唯一索引前先整理既有資料：沒有編號的訂單補上 LEGACY-<id>，
+-- 重複的編號保留 id 最小的一筆，其餘加上 -<id> 後綴 (外送紀錄沿用原編號，對應到保留的那一筆)
+UPDATE orders SET code = CONCAT('LEGACY-', id) WHERE code IS NULL OR code = '';
+
+UPDATE orders o
+    JOIN (SELECT code, MIN(id) AS keep_id FROM orders GROUP BY code HAVING COUNT(*) > 1) d ON d.code = o.code
+SET o.code = CONCAT(o.code, '-', o.id)
+WHERE o.id <> d.keep_id;
+
+ALTER TABLE orders
+    MODIFY COLUMN code VARCHAR(32) NOT NULL,
+    ADD UNIQUE KEY uk_orders_code (code);
+
+UPDATE order_delivery SET order_code = '' WHERE order_code IS NULL;
+
+ALTER TABLE order_delivery
+    MODIFY COLUMN order_code VARCHAR(32) NOT NULL;
+
+CREATE TABLE IF NOT EXISTS OrderCodeSequences (
+    SeqDate    DATE NOT NULL,
+    LocationID INT NOT NULL,
+    LastValue  INT NOT NULL,
+    PRIMARY KEY (SeqDate, LocationID)
+);
@@ -0,0 +1,5 @@
+-- 014_order_product_sort.sql
+-- 主餐的顯示順序，修改訂單餐點時可以調整
+
+ALTER TABLE order_products
+    ADD COLUMN sort_order INT NOT NULL DEFAULT 0;
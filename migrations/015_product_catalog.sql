@@ -0,0 +1,46 @@
+-- 015_product_catalog.sql
+-- 商品目錄：主餐、選項群組 (附餐、飲料) 及選項，價格以元為單位
+-- 選項群組可以套用在多個主餐上，MinSelect/MaxSelect 限制每份主餐可選的數量 (MaxSelect 0 表示不限)
+-- 訂單明細保存下單當時的名稱及單價
+
+CREATE TABLE IF NOT EXISTS products (
+    id          INT AUTO_INCREMENT PRIMARY KEY,
+    name        VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    price       INT NOT NULL DEFAULT 0,
+    image_id    INT NULL,
+    available   TINYINT(1) NOT NULL DEFAULT 1,
+    sort_order  INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS product_option_groups (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    min_select INT NOT NULL DEFAULT 0,
+    max_select INT NOT NULL DEFAULT 0,
+    sort_order INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS product_option_group_links (
+    product_id INT NOT NULL,
+    group_id   INT NOT NULL,
+    PRIMARY KEY (product_id, group_id),
+    KEY idx_group (group_id)
+);
+
+CREATE TABLE IF NOT EXISTS product_options (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    group_id   INT NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    price      INT NOT NULL DEFAULT 0,
+    image_id   INT NULL,
+    available  TINYINT(1) NOT NULL DEFAULT 1,
+    sort_order INT NOT NULL DEFAULT 0,
+    KEY idx_group (group_id)
+);
+
+ALTER TABLE order_products
+    ADD COLUMN unit_price INT NOT NULL DEFAULT 0;
+
+ALTER TABLE order_product_options
+    ADD COLUMN unit_price INT NOT NULL DEFAULT 0;
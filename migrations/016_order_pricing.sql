@@ -0,0 +1,28 @@
+-- 016_order_pricing.sql
+-- 訂單金額由伺服器依商品目錄計算：小計 - 折扣 + 運費 = 總額，金額以元為單位
+-- 價格為含稅價，tax 記錄總額中包含的稅額
+
+ALTER TABLE orders
+    ADD COLUMN subtotal      INT NOT NULL DEFAULT 0,
+    ADD COLUMN discount      INT NOT NULL DEFAULT 0,
+    ADD COLUMN delivery_fee  INT NOT NULL DEFAULT 0,
+    ADD COLUMN tax           INT NOT NULL DEFAULT 0,
+    ADD COLUMN total         INT NOT NULL DEFAULT 0,
+    ADD COLUMN discount_code VARCHAR(50) NOT NULL DEFAULT '';
+
+-- 各外送區域的運費，FreeDeliveryOver 為折扣後金額達到多少免運 (0 表示不免運)
+ALTER TABLE DeliveryZones
+    ADD COLUMN DeliveryFee      INT NOT NULL DEFAULT 0,
+    ADD COLUMN FreeDeliveryOver INT NOT NULL DEFAULT 0;
+
+-- 折扣碼：kind 為 percent (value 為百分比) 或 amount (value 為折抵金額)
+-- StartsOn/EndsOn 為 NULL 表示不限日期，以外送日期判斷
+CREATE TABLE IF NOT EXISTS discount_codes (
+    code         VARCHAR(50) PRIMARY KEY,
+    kind         VARCHAR(10) NOT NULL,
+    value        INT NOT NULL,
+    min_subtotal INT NOT NULL DEFAULT 0,
+    starts_on    DATE NULL,
+    ends_on      DATE NULL,
+    active       TINYINT(1) NOT NULL DEFAULT 1
+);
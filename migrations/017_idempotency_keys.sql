@@ -0,0 +1,16 @@
+-- 017_idempotency_keys.sql
+-- 依 Idempotency-Key 保存第一次請求的回應，重試時直接回放
+-- Scope 區分不同的 API；ResponseStatus 為 NULL 表示第一次請求仍在處理中
+
+CREATE TABLE IF NOT EXISTS IdempotencyKeys (
+    Scope          VARCHAR(50) NOT NULL,
+    IdemKey        VARCHAR(255) NOT NULL,
+    RequestHash    CHAR(64) NOT NULL,
+    ResponseStatus INT NULL,
+    ContentType    VARCHAR(255) NOT NULL DEFAULT '',
+    ResponseBody   MEDIUMBLOB NULL,
+    ExpiresAt      DATETIME NOT NULL,
+    CreatedAt      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (Scope, IdemKey),
+    KEY idx_expires (ExpiresAt)
+);
@@ -0,0 +1,6 @@
+-- 018_waitlist_cancel_token.sql
+-- 登記候補時發給客人的取消憑證，只有持有憑證的客人或管理員可以取消
+-- 之前登記的候補沒有憑證，只能由管理員取消
+
+ALTER TABLE Waitlist
+    ADD COLUMN CancelToken CHAR(32) NULL;
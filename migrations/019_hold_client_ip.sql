@@ -0,0 +1,7 @@
+-- 019_hold_client_ip.sql
+-- 記錄建立保留的來源 IP，限制同一個用戶端同時保留的數量
+-- 候補轉成的保留沒有來源 IP，不受限制
+
+ALTER TABLE CapacityHolds
+    ADD COLUMN ClientIP VARCHAR(45) NOT NULL DEFAULT '',
+    ADD KEY idx_client_status (ClientIP, Status);